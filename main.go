@@ -19,9 +19,11 @@ package main
 import (
 	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/alauda/captain/pkg/cluster"
 
+	"github.com/alauda/captain/pkg/chartproxy"
 	"github.com/alauda/captain/pkg/chartrepo"
 	"github.com/alauda/captain/pkg/util"
 
@@ -32,6 +34,7 @@ import (
 	"github.com/alauda/captain/pkg/config"
 	"github.com/alauda/captain/pkg/controller"
 	"github.com/alauda/captain/pkg/helm"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -71,6 +74,11 @@ func main() {
 		klog.Fatalf("Error building kubeconfig: %s", err.Error())
 	}
 
+	// all the replicas work on their own shards, there is no leader
+	if options.EnableSharding {
+		options.LeaderElection = false
+	}
+
 	// create manager
 	mgr, err := manager.New(cfg, options.Options)
 	if err != nil {
@@ -88,6 +96,23 @@ func main() {
 		klog.Fatal("add cluster refresher runner error: ", err)
 	}
 
+	// init chart cache
+	var chartCache *chartproxy.Cache
+	if options.ChartCacheDir != "" {
+		chartCache, err = chartproxy.NewCache(options.ChartCacheDir)
+		if err != nil {
+			klog.Fatal("init chart cache error: ", err)
+		}
+		helm.SetChartCache(chartCache)
+	} else if options.ChartProxyBindAddress != "" {
+		klog.Fatal("chart proxy requires chart-cache-dir to be set")
+	}
+
+	// keys to decrypt values encrypted by sops
+	helm.SetDecryptionKeySecret(options.ChartRepoNamespace, options.DecryptionKeySecret)
+	// values to mask in logs and events
+	helm.SetRedactKeyPatterns(strings.Split(options.RedactKeyPatterns, ","))
+
 	// install HelmRequest CRD
 	if err := util.InstallCRDIfRequired(cfg, options.InstallCRD); err != nil {
 		klog.Fatalf("Error install CRD: %s", err.Error())
@@ -103,14 +128,39 @@ func main() {
 	}
 
 	// create controller
-	_, err = controller.NewController(mgr, &options, stopCh)
+	ctrl, err := controller.NewController(mgr, &options, stopCh)
 	if err != nil {
 		klog.Fatalf("create controller error: %s", err.Error())
 	}
 
+	// add chart proxy, which also serves the local chartrepos
+	if chartCache != nil {
+		ctrl.SetChartCache(chartCache)
+		if options.ChartProxyBindAddress != "" {
+			serverOptions := chartproxy.ServerOptions{
+				EnableUploadAPI:  options.EnableChartUploadAPI,
+				DefaultLocalRepo: options.DefaultLocalRepo,
+			}
+			if options.ChartProxyAuthSecret != "" {
+				kubeClient, err := kubernetes.NewForConfig(cfg)
+				if err != nil {
+					klog.Fatal("create kubernetes client error: ", err)
+				}
+				serverOptions.Auth = chartproxy.NewAuth(kubeClient, options.ChartRepoNamespace, options.ChartProxyAuthSecret)
+			}
+			server, err := chartproxy.NewServer(options.ChartProxyBindAddress, chartCache, ctrl, serverOptions)
+			if err != nil {
+				klog.Fatal("create chart proxy server error: ", err)
+			}
+			if err := mgr.Add(server); err != nil {
+				klog.Fatal("add chart proxy server error: ", err)
+			}
+		}
+	}
+
 	// add webhook
 	if options.EnableValidateWebhook {
-		if err := webhook.RegisterHandlers(mgr); err != nil {
+		if err := webhook.RegisterHandlers(mgr, options.ChartRepoNamespace); err != nil {
 			klog.Fatal("register handlers for webhook error : ", err)
 		}
 	}
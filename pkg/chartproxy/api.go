@@ -0,0 +1,146 @@
+package chartproxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// maxChartSize is the max size of an uploaded chart archive
+const maxChartSize = 20 << 20
+
+// serveAPI handles the ChartMuseum compatible api for local repos
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.EnableUploadAPI {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !s.requireAuth(w, r) {
+		return
+	}
+
+	repoName, args, ok := parseAPIPath(r.URL.Path, s.DefaultLocalRepo)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if s.repos == nil || !s.repos.IsLocalRepo(repoName) {
+		writeError(w, http.StatusNotFound, "local repo "+repoName+" not found")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(args) == 0:
+		s.uploadChart(w, r, repoName)
+	case r.Method == http.MethodDelete && len(args) == 2:
+		s.deleteChart(w, repoName, args[0], args[1])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// parseAPIPath splits the api path into the repo and the args after charts, the paths without a repo
+// are for the default repo:
+//
+//	charts[/<name>/<version>]        -> defaultRepo
+//	<repo>/charts[/<name>/<version>] -> repo
+func parseAPIPath(p, defaultRepo string) (string, []string, bool) {
+	ss := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case len(ss)%2 == 1 && ss[0] == "charts":
+		return defaultRepo, ss[1:], defaultRepo != ""
+	case len(ss)%2 == 0 && ss[1] == "charts":
+		return ss[0], ss[2:], true
+	}
+	return "", nil, false
+}
+
+// uploadChart accepts a chart archive as the request body, or as the "chart" field of a multipart
+// form, with an optional "prov" field for the provenance file.
+func (s *Server) uploadChart(w http.ResponseWriter, r *http.Request, repoName string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxChartSize)
+
+	var data, prov []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, prov, err = readForm(r)
+	} else {
+		data, err = ioutil.ReadAll(r.Body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	md, err := s.cache.SaveChart(repoName, data, prov, force)
+	if err != nil {
+		if err == ErrChartExists {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		klog.Warningf("upload chart to %s error: %s", repoName, err.Error())
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	klog.Infof("chart %s-%s uploaded to local repo %s", md.Name, md.Version, repoName)
+	s.repos.RefreshRepo(repoName)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"saved": true})
+}
+
+func (s *Server) deleteChart(w http.ResponseWriter, repoName, name, version string) {
+	if err := s.cache.DeleteChart(repoName, name, version); err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, http.StatusNotFound, "chart not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.repos.RefreshRepo(repoName)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": true})
+}
+
+// readForm read chart and prov data from a multipart form
+func readForm(r *http.Request) ([]byte, []byte, error) {
+	if err := r.ParseMultipartForm(maxChartSize); err != nil {
+		return nil, nil, err
+	}
+
+	f, _, err := r.FormFile("chart")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pf, _, err := r.FormFile("prov")
+	if err == http.ErrMissingFile {
+		return data, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer pf.Close()
+	prov, err := ioutil.ReadAll(pf)
+	return data, prov, err
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError returns error in ChartMuseum's format
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
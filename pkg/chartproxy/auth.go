@@ -0,0 +1,92 @@
+package chartproxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// authSecretTTL is how long the auth Secret is cached, the rotated credentials take effect after it
+const authSecretTTL = 10 * time.Second
+
+// Auth authenticates the requests to the chart proxy with the credentials in a Secret, either basic auth
+// with the username and password keys, or a bearer token with the token key. The Secret is cached for a
+// short time, so the credentials can be rotated without restarting captain, and the requests, even the
+// unauthenticated ones, don't read it from the apiserver every time.
+type Auth struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	lock sync.Mutex
+	// credentials is the data of the Secret read at readTime, nil if it cannot be read
+	credentials map[string][]byte
+	readTime    time.Time
+}
+
+// NewAuth creates an Auth with the Secret namespace/name
+func NewAuth(client kubernetes.Interface, namespace, name string) *Auth {
+	return &Auth{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Authenticate checks if the request has the credentials in the Secret
+func (a *Auth) Authenticate(r *http.Request) bool {
+	return authenticate(r, a.getCredentials(time.Now()))
+}
+
+// getCredentials returns the data of the Secret, it's read again if the cached one is older than authSecretTTL
+func (a *Auth) getCredentials(now time.Time) map[string][]byte {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.readTime.IsZero() && now.Sub(a.readTime) < authSecretTTL {
+		return a.credentials
+	}
+
+	// the errors are cached too, the requests keep failing until the next read
+	a.credentials, a.readTime = nil, now
+	secret, err := a.client.CoreV1().Secrets(a.namespace).Get(a.name, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("get chart proxy auth secret %s/%s error: %s", a.namespace, a.name, err.Error())
+		return nil
+	}
+	a.credentials = secret.Data
+	return a.credentials
+}
+
+// authenticate checks the basic auth or bearer token of the request against the credentials, the empty
+// ones are never matched
+func authenticate(r *http.Request, credentials map[string][]byte) bool {
+	if username, password, ok := r.BasicAuth(); ok {
+		return equal(username, credentials["username"]) && equal(password, credentials["password"])
+	}
+
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return equal(strings.TrimPrefix(header, "Bearer "), credentials["token"])
+	}
+	return false
+}
+
+func equal(s string, expected []byte) bool {
+	return len(expected) > 0 && subtle.ConstantTimeCompare([]byte(s), expected) == 1
+}
+
+// requireAuth returns true if the request is authenticated, or writes 401 and returns false
+func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) bool {
+	if s.Auth != nil && s.Auth.Authenticate(r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="captain"`)
+	writeError(w, http.StatusUnauthorized, "unauthorized")
+	return false
+}
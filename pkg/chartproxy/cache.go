@@ -0,0 +1,288 @@
+package chartproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/pkg/cli"
+	"helm.sh/helm/pkg/getter"
+	"helm.sh/helm/pkg/provenance"
+	"helm.sh/helm/pkg/repo"
+	"k8s.io/klog"
+)
+
+// Cache stores the indexes and chart archives of all the ChartRepos in a local directory,
+// which is usually a persistent volume shared by all the captain replicas.
+// Layout:
+//
+//	<dir>/<repo>/index.yaml
+//	<dir>/<repo>/<chart>-<version>.tgz
+//	<dir>/<repo>/<chart>-<version>.tgz.prov
+type Cache struct {
+	dir string
+	// repos knows the local repos, whose charts are stored here and never downloaded. nil means none
+	repos LocalRepos
+
+	lock  sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewCache create a chart cache in dir
+func NewCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		dir:   dir,
+		locks: make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// fileLock returns the lock for a cached file, so concurrent requests for the same chart will
+// only download it once
+func (c *Cache) fileLock(name string) *sync.Mutex {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	l, ok := c.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[name] = l
+	}
+	return l
+}
+
+// SetLocalRepos set the local repos, their charts are served as they are stored
+func (c *Cache) SetLocalRepos(repos LocalRepos) {
+	c.repos = repos
+}
+
+func (c *Cache) isLocalRepo(name string) bool {
+	return c.repos != nil && c.repos.IsLocalRepo(name)
+}
+
+func (c *Cache) repoDir(name string) string {
+	return filepath.Join(c.dir, name)
+}
+
+// validateNames checks the repo names, chart names, versions and file names can be used as a single
+// element of a path in the cache dir
+func validateNames(names ...string) error {
+	for _, name := range names {
+		if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) ||
+			strings.ContainsRune(name, filepath.Separator) || name != filepath.Base(name) {
+			return fmt.Errorf("invalid name: %q", name)
+		}
+	}
+	return nil
+}
+
+// Index returns the index of a repo. The index is refreshed by the ChartRepo sync process, we keep
+// a copy of it in the cache dir, so the charts can still be served when the upstream repo is not
+// reachable. The copy is only rewritten when the upstream index is changed.
+func (c *Cache) Index(name string) (*repo.IndexFile, error) {
+	if err := validateNames(name); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(c.repoDir(name), "index.yaml")
+	l := c.fileLock(p)
+	l.Lock()
+	defer l.Unlock()
+
+	index, err := helm.GetChartsForRepo(name)
+	if err != nil {
+		klog.Warningf("load index for repo %s error: %s, use the cached one", name, err.Error())
+		return repo.LoadIndexFile(p)
+	}
+
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if old, err := ioutil.ReadFile(p); err == nil && bytes.Equal(old, data) {
+		return index, nil
+	}
+	if err := c.writeFile(name, "index.yaml", data); err != nil {
+		klog.Warningf("cache index for repo %s error: %s", name, err.Error())
+	}
+	return index, nil
+}
+
+// Locate returns the local path of a chart archive, download it if not cached. If prov is true,
+// the provenance file is also downloaded along with the chart.
+// This implements helm.ChartCache
+func (c *Cache) Locate(repoName, chart, version string, prov bool) (string, error) {
+	filename, err := c.chartFilename(repoName, chart, version)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := c.Chart(repoName, filename)
+	if err != nil {
+		return "", err
+	}
+	if prov {
+		if _, err := c.Chart(repoName, filename+".prov"); err != nil {
+			return "", err
+		}
+	}
+	return p, nil
+}
+
+// Cached returns the local path of a chart archive only if it's already cached, nothing is downloaded.
+// If the chart is not cached, returns os.ErrNotExist.
+// This implements helm.ChartCache
+func (c *Cache) Cached(repoName, chart, version string) (string, error) {
+	filename, err := c.chartFilename(repoName, chart, version)
+	if err != nil {
+		return "", err
+	}
+	if err := validateNames(repoName, filename); err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(c.repoDir(repoName), filename)
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// chartFilename finds the file name of a chart version from the repo index
+func (c *Cache) chartFilename(repoName, chart, version string) (string, error) {
+	index, err := c.Index(repoName)
+	if err != nil {
+		return "", err
+	}
+
+	cv, err := index.Get(chart, version)
+	if err != nil {
+		return "", err
+	}
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("chart %s/%s-%s has no downloadable URLs", repoName, chart, cv.Version)
+	}
+	return path.Base(cv.URLs[0]), nil
+}
+
+// Chart returns the local path of a file in the repo, which can be a chart archive or a provenance
+// file. If the file not exist in the cache, it will be downloaded from the upstream repo first. The
+// archives are verified against the digests in the index, the cached one is downloaded again if the
+// upstream republished the version with a different digest.
+func (c *Cache) Chart(repoName, filename string) (string, error) {
+	if err := validateNames(repoName, filename); err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(c.repoDir(repoName), filename)
+	l := c.fileLock(p)
+	l.Lock()
+	defer l.Unlock()
+
+	_, statErr := os.Stat(p)
+	if c.isLocalRepo(repoName) {
+		return p, statErr
+	}
+
+	u, digest, err := c.findURL(repoName, filename)
+	if statErr == nil {
+		if err != nil {
+			klog.Warningf("find chart file %s in repo %s error: %s, use the cached one", filename, repoName, err.Error())
+			return p, nil
+		}
+		cached, err := provenance.DigestFile(p)
+		if err != nil {
+			return "", err
+		}
+		if digest == "" || cached == digest {
+			return p, nil
+		}
+		klog.Infof("digest of cached chart file %s/%s is changed in the index, download it again", repoName, filename)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	klog.Infof("download chart file %s to cache from %s", filename, u)
+	data, err := download(repoName, u)
+	if err != nil {
+		return "", err
+	}
+	if digest != "" {
+		actual, err := provenance.Digest(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		if actual != digest {
+			return "", fmt.Errorf("digest of chart file %s/%s is %s, expected %s in the index", repoName, filename, actual, digest)
+		}
+	}
+
+	return p, c.writeFile(repoName, filename, data)
+}
+
+// findURL find the upstream url of a chart file from the repo index, and the digest of it if it's
+// a chart archive
+func (c *Cache) findURL(repoName, filename string) (string, string, error) {
+	index, err := c.Index(repoName)
+	if err != nil {
+		return "", "", err
+	}
+
+	entry, err := helm.GetRepository(repoName)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, versions := range index.Entries {
+		for _, cv := range versions {
+			for _, u := range cv.URLs {
+				if path.Base(u) == filename {
+					resolved, err := repo.ResolveReferenceURL(entry.URL, u)
+					return resolved, cv.Digest, err
+				}
+				if path.Base(u)+".prov" == filename {
+					resolved, err := repo.ResolveReferenceURL(entry.URL, u+".prov")
+					return resolved, "", err
+				}
+			}
+		}
+	}
+	return "", "", os.ErrNotExist
+}
+
+// download get a file from the upstream repo with the auth info of the repo
+func download(repoName, href string) ([]byte, error) {
+	entry, err := helm.GetRepository(repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := getter.All(cli.New()).ByScheme(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := g.Get(href,
+		getter.WithURL(entry.URL),
+		getter.WithBasicAuth(entry.Username, entry.Password),
+		getter.WithTLSClientConfig(entry.CertFile, entry.KeyFile, entry.CAFile),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
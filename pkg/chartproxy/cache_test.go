@@ -0,0 +1,132 @@
+package chartproxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/helmpath"
+	"helm.sh/helm/pkg/helmpath/xdg"
+	"helm.sh/helm/pkg/provenance"
+	"helm.sh/helm/pkg/repo"
+)
+
+// setupRepo adds a helm repo named stable with nginx-1.0.0 served by server, and returns the counter
+// of the chart downloads. The repo has credentials if username is not empty
+func setupRepo(t *testing.T, dir, username string) (*httptest.Server, *int) {
+	for _, env := range []string{xdg.CacheHomeEnvVar, xdg.ConfigHomeEnvVar} {
+		os.Setenv(env, filepath.Join(dir, env))
+	}
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nginx-1.0.0.tgz" {
+			http.NotFound(w, r)
+			return
+		}
+		downloads++
+		http.ServeFile(w, r, filepath.Join(dir, "upstream", "nginx-1.0.0.tgz"))
+	}))
+
+	f := repo.NewFile()
+	f.Add(&repo.Entry{Name: "stable", URL: server.URL, Username: username, Password: username})
+	p := helmpath.ConfigPath("repositories.yaml")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.Nil(t, f.WriteFile(p, 0644))
+
+	publishChart(t, dir, server.URL, "chart", "chart")
+	return server, &downloads
+}
+
+// publishChart serves data as nginx-1.0.0 in the upstream repo, the index says it's digest is the one of indexed
+func publishChart(t *testing.T, dir, url, data, indexed string) {
+	p := filepath.Join(dir, "upstream", "nginx-1.0.0.tgz")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.Nil(t, ioutil.WriteFile(p, []byte(data), 0644))
+
+	digest, err := provenance.Digest(strings.NewReader(indexed))
+	assert.Nil(t, err)
+	index := repo.NewIndexFile()
+	index.Add(&chart.Metadata{Name: "nginx", Version: "1.0.0"}, "nginx-1.0.0.tgz", url, digest)
+	p = helmpath.CachePath("repository", "stable-index.yaml")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.Nil(t, index.WriteFile(p, 0644))
+}
+
+func TestLocate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captain-chart-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	server, downloads := setupRepo(t, dir, "")
+	defer server.Close()
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+
+	p, err := cache.Locate("stable", "nginx", "1.0.0", false)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "chart", string(data))
+
+	// cached
+	_, err = cache.Locate("stable", "nginx", "", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *downloads)
+
+	_, err = cache.Locate("stable", "nginx", "2.0.0", false)
+	assert.NotNil(t, err)
+
+	// republished with a different archive
+	publishChart(t, dir, server.URL, "chart-v2", "chart-v2")
+	p, err = cache.Locate("stable", "nginx", "1.0.0", false)
+	assert.Nil(t, err)
+	data, err = ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "chart-v2", string(data))
+	assert.Equal(t, 2, *downloads)
+
+	// the archive doesn't match the index, the cached one is kept
+	publishChart(t, dir, server.URL, "tampered", "chart-v3")
+	_, err = cache.Locate("stable", "nginx", "1.0.0", false)
+	assert.NotNil(t, err)
+	data, err = ioutil.ReadFile(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "chart-v2", string(data))
+}
+
+func TestChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captain-chart-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	server, downloads := setupRepo(t, dir, "")
+	defer server.Close()
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+
+	p, err := cache.Chart("stable", "nginx-1.0.0.tgz")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "cache", "stable", "nginx-1.0.0.tgz"), p)
+	_, err = cache.Chart("stable", "nginx-1.0.0.tgz")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *downloads)
+
+	_, err = cache.Chart("stable", "redis-1.0.0.tgz")
+	assert.True(t, os.IsNotExist(err))
+
+	// the index is only rewritten when changed
+	before, err := os.Stat(filepath.Join(dir, "cache", "stable", "index.yaml"))
+	assert.Nil(t, err)
+	_, err = cache.Index("stable")
+	assert.Nil(t, err)
+	after, err := os.Stat(filepath.Join(dir, "cache", "stable", "index.yaml"))
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(before, after))
+}
@@ -0,0 +1,122 @@
+package chartproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chart/loader"
+	"helm.sh/helm/pkg/repo"
+	"k8s.io/klog"
+)
+
+// ErrChartExists means the pushed chart version already exists in a local repo
+var ErrChartExists = fmt.Errorf("chart version already exists")
+
+// LocalIndex generate the index of a local repo from the chart archives stored in the cache.
+// url is the url of the repo, all the chart urls in the index are relative to it.
+func (c *Cache) LocalIndex(repoName, url string) (*repo.IndexFile, error) {
+	if err := validateNames(repoName); err != nil {
+		return nil, err
+	}
+	dir := c.repoDir(repoName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	index, err := repo.IndexDirectory(dir, url)
+	if err != nil {
+		return nil, err
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+// SaveChart stores a chart archive to a local repo. If prov is not empty, it will be stored as the
+// provenance file of the chart. If the same version exists and force is false, returns ErrChartExists.
+func (c *Cache) SaveChart(repoName string, data, prov []byte, force bool) (*chart.Metadata, error) {
+	ch, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Validate(); err != nil {
+		return nil, err
+	}
+	if err := validateNames(repoName, ch.Name(), ch.Metadata.Version); err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("%s-%s.tgz", ch.Name(), ch.Metadata.Version)
+	p := filepath.Join(c.repoDir(repoName), filename)
+	l := c.fileLock(p)
+	l.Lock()
+	defer l.Unlock()
+
+	if _, err := os.Stat(p); err == nil && !force {
+		return nil, ErrChartExists
+	}
+
+	// the provenance file of the overwritten archive doesn't match the new one
+	if len(prov) == 0 {
+		if err := os.Remove(p + ".prov"); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err := c.writeFile(repoName, filename, data); err != nil {
+		return nil, err
+	}
+	if len(prov) > 0 {
+		if err := c.writeFile(repoName, filename+".prov", prov); err != nil {
+			return nil, err
+		}
+	}
+	klog.Infof("saved chart %s to local repo %s", filename, repoName)
+	return ch.Metadata, nil
+}
+
+// DeleteChart remove a chart version from a local repo
+func (c *Cache) DeleteChart(repoName, name, version string) error {
+	if err := validateNames(repoName, name, version); err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("%s-%s.tgz", name, version)
+
+	p := filepath.Join(c.repoDir(repoName), filename)
+	l := c.fileLock(p)
+	l.Lock()
+	defer l.Unlock()
+
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	if err := os.Remove(p + ".prov"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	klog.Infof("deleted chart %s from local repo %s", filename, repoName)
+	return nil
+}
+
+// writeFile write a file to the repo dir. The data is written to a temp file first and then renamed,
+// so others will never see a partial file.
+func (c *Cache) writeFile(repoName, filename string, data []byte) error {
+	dir := c.repoDir(repoName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filename)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, filename))
+}
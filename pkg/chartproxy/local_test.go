@@ -0,0 +1,65 @@
+package chartproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chartutil"
+)
+
+func TestSaveChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captain-chart-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion: chart.APIVersionV1,
+			Name:       "demo",
+			Version:    "0.1.0",
+		},
+	}
+	p, err := chartutil.Save(ch, dir)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadFile(p)
+	assert.Nil(t, err)
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+
+	md, err := cache.SaveChart("local", data, nil, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", md.Name)
+
+	_, err = cache.SaveChart("local", data, nil, false)
+	assert.Equal(t, ErrChartExists, err)
+	_, err = cache.SaveChart("local", data, []byte("prov"), true)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "cache", "local", "demo-0.1.0.tgz.prov"))
+	assert.Nil(t, err)
+	// the stale provenance file is removed when overwritten without one
+	_, err = cache.SaveChart("local", data, nil, true)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "cache", "local", "demo-0.1.0.tgz.prov"))
+	assert.True(t, os.IsNotExist(err))
+
+	index, err := cache.LocalIndex("local", "http://captain/charts/local")
+	assert.Nil(t, err)
+	assert.True(t, index.Has("demo", "0.1.0"))
+	assert.Equal(t, []string{"http://captain/charts/local/demo-0.1.0.tgz"}, index.Entries["demo"][0].URLs)
+
+	assert.Nil(t, cache.DeleteChart("local", "demo", "0.1.0"))
+	index, err = cache.LocalIndex("local", "http://captain/charts/local")
+	assert.Nil(t, err)
+	assert.False(t, index.Has("demo", "0.1.0"))
+
+	assert.NotNil(t, cache.DeleteChart("local", "../demo", "0.1.0"))
+	assert.NotNil(t, cache.DeleteChart("local", "demo", "0.1.0/.."))
+	assert.NotNil(t, cache.DeleteChart("..", "demo", "0.1.0"))
+	_, err = cache.Chart("local", "..")
+	assert.NotNil(t, err)
+}
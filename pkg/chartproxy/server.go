@@ -0,0 +1,191 @@
+package chartproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/pkg/repo"
+	"k8s.io/klog"
+)
+
+// LocalRepos knows about the local ChartRepos, which are stored in the cache by captain itself
+type LocalRepos interface {
+	// IsLocalRepo check if the ChartRepo exist and is a local one
+	IsLocalRepo(name string) bool
+	// RefreshRepo is called after charts changed in a local repo, to update it's index and Chart resources
+	RefreshRepo(name string)
+}
+
+// Server serves all the ChartRepos as helm repositories, the charts are cached in Cache. The
+// url of a repo is http://<captain>/charts/<repo>
+// For local repos, there is also a ChartMuseum compatible api to upload and delete charts, it must be
+// enabled explicitly and always requires authentication:
+//
+//	POST   /api/charts
+//	DELETE /api/charts/<name>/<version>
+//	POST   /api/<repo>/charts
+//	DELETE /api/<repo>/charts/<name>/<version>
+//
+// The paths without a repo are for the default local repo, like a single tenant ChartMuseum, the others
+// are like a multi-tenant ChartMuseum whose repo url is http://<chartmuseum>/<repo>.
+type Server struct {
+	addr  string
+	cache *Cache
+	repos LocalRepos
+	ServerOptions
+}
+
+// ServerOptions are the options of the chart proxy server
+type ServerOptions struct {
+	// Auth authenticates the requests, nil means the requests are not authenticated
+	Auth *Auth
+	// EnableUploadAPI enables the api to upload and delete charts, requires Auth
+	EnableUploadAPI bool
+	// DefaultLocalRepo is the local repo of the api paths without a repo
+	DefaultLocalRepo string
+}
+
+// NewServer create a chart proxy server, it should be add to manager to start
+func NewServer(addr string, cache *Cache, repos LocalRepos, options ServerOptions) (*Server, error) {
+	if options.EnableUploadAPI && options.Auth == nil {
+		return nil, fmt.Errorf("the chart upload api requires authentication")
+	}
+	return &Server{
+		addr:          addr,
+		cache:         cache,
+		repos:         repos,
+		ServerOptions: options,
+	}, nil
+}
+
+// Start runs the http server until stopCh is closed
+func (s *Server) Start(stopCh <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/charts/", http.StripPrefix("/charts/", http.HandlerFunc(s.serveChartRepo)))
+	mux.Handle("/api/", http.StripPrefix("/api/", http.HandlerFunc(s.serveAPI)))
+
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			klog.Error("shutdown chart proxy server error: ", err)
+		}
+	}()
+
+	klog.Info("start chart proxy server on: ", s.addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection implements LeaderElectionRunnable, every replica can serve charts
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// serveChartRepo handles <repo>/index.yaml and <repo>/<file>
+func (s *Server) serveChartRepo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ss := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	repoName, filename := ss[0], ss[1]
+
+	// the charts of private repos are fetched with the credentials of the repo, don't leak them
+	private, err := isPrivateRepo(repoName)
+	if err != nil {
+		klog.Warningf("get repo %s error: %s", repoName, err.Error())
+		http.NotFound(w, r)
+		return
+	}
+	if private && !s.requireAuth(w, r) {
+		return
+	}
+
+	if filename == "index.yaml" {
+		s.serveIndex(w, repoName)
+		return
+	}
+
+	p, err := s.cache.Chart(repoName, filename)
+	if err != nil {
+		klog.Warningf("get chart file %s/%s error: %s", repoName, filename, err.Error())
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
+// isPrivateRepo checks if the upstream repo requires credentials, the local repos have none
+func isPrivateRepo(name string) (bool, error) {
+	entry, err := helm.GetRepository(name)
+	if err != nil {
+		return false, err
+	}
+	return entry.Username != "" || entry.Password != "" || entry.CertFile != "" || entry.KeyFile != "", nil
+}
+
+// serveIndex returns the index of a repo, all the chart urls are rewritten to point to this server
+func (s *Server) serveIndex(w http.ResponseWriter, name string) {
+	index, err := s.cache.Index(name)
+	if err != nil {
+		klog.Warningf("get index for repo %s error: %s", name, err.Error())
+		if os.IsNotExist(err) {
+			http.Error(w, "repo not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	data, err := yaml.Marshal(rewriteIndex(index))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write(data)
+}
+
+// rewriteIndex make all the chart urls relative to the repo url
+func rewriteIndex(index *repo.IndexFile) *repo.IndexFile {
+	result := *index
+	result.Entries = make(map[string]repo.ChartVersions)
+	for name, versions := range index.Entries {
+		var vs repo.ChartVersions
+		for _, cv := range versions {
+			v := *cv
+			v.URLs = nil
+			for _, u := range cv.URLs {
+				v.URLs = append(v.URLs, path.Base(u))
+			}
+			vs = append(vs, &v)
+		}
+		result.Entries[name] = vs
+	}
+	return &result
+}
@@ -0,0 +1,113 @@
+package chartproxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/repo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRewriteIndex(t *testing.T) {
+	index := repo.NewIndexFile()
+	index.Add(&chart.Metadata{Name: "nginx", Version: "1.0.0"}, "nginx-1.0.0.tgz", "https://example.com/charts", "sha")
+
+	result := rewriteIndex(index)
+	assert.Equal(t, []string{"nginx-1.0.0.tgz"}, result.Entries["nginx"][0].URLs)
+	// origin index should not be changed
+	assert.Equal(t, []string{"https://example.com/charts/nginx-1.0.0.tgz"}, index.Entries["nginx"][0].URLs)
+}
+
+func TestAuthenticate(t *testing.T) {
+	credentials := map[string][]byte{
+		"username": []byte("admin"),
+		"password": []byte("secret"),
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/charts", nil)
+	assert.False(t, authenticate(r, credentials))
+	r.SetBasicAuth("admin", "wrong")
+	assert.False(t, authenticate(r, credentials))
+	r.SetBasicAuth("admin", "secret")
+	assert.True(t, authenticate(r, credentials))
+
+	// the token is not set
+	r = httptest.NewRequest(http.MethodPost, "/api/charts", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	assert.False(t, authenticate(r, credentials))
+	credentials["token"] = []byte("token")
+	r.Header.Set("Authorization", "Bearer token")
+	assert.True(t, authenticate(r, credentials))
+}
+
+func TestParseAPIPath(t *testing.T) {
+	for _, item := range []struct {
+		path string
+		repo string
+		args []string
+		ok   bool
+	}{
+		{"/charts", "local", []string{}, true},
+		{"/charts/nginx/1.0.0", "local", []string{"nginx", "1.0.0"}, true},
+		{"/internal/charts", "internal", []string{}, true},
+		{"/internal/charts/nginx/1.0.0/", "internal", []string{"nginx", "1.0.0"}, true},
+		{"/charts/charts", "charts", []string{}, true},
+		{"/internal/nginx", "", nil, false},
+		{"/", "", nil, false},
+	} {
+		repo, args, ok := parseAPIPath(item.path, "local")
+		assert.Equal(t, item.ok, ok)
+		assert.Equal(t, item.repo, repo)
+		assert.Equal(t, item.args, args)
+	}
+}
+
+func TestServePrivateRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captain-chart-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	upstream, _ := setupRepo(t, dir, "admin")
+	defer upstream.Close()
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	assert.Nil(t, err)
+
+	// no auth configured
+	s, err := NewServer(":0", cache, nil, ServerOptions{})
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	s.serveChartRepo(w, httptest.NewRequest(http.MethodGet, "/stable/nginx-1.0.0.tgz", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	_, err = NewServer(":0", cache, nil, ServerOptions{EnableUploadAPI: true})
+	assert.NotNil(t, err)
+}
+
+func TestAuthCredentials(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "captain", Name: "captain-chart-proxy"},
+		Data:       map[string][]byte{"token": []byte("token")},
+	})
+	a := NewAuth(client, "captain", "captain-chart-proxy")
+
+	now := time.Now()
+	assert.Equal(t, "token", string(a.getCredentials(now)["token"]))
+	assert.Equal(t, "token", string(a.getCredentials(now.Add(time.Second))["token"]))
+	assert.Equal(t, 1, len(client.Actions()))
+
+	// read again after the ttl
+	assert.Nil(t, client.CoreV1().Secrets("captain").Delete("captain-chart-proxy", nil))
+	assert.Equal(t, "token", string(a.getCredentials(now.Add(2 * time.Second))["token"]))
+	assert.Nil(t, a.getCredentials(now.Add(authSecretTTL)))
+	assert.Nil(t, a.getCredentials(now.Add(authSecretTTL+time.Second)))
+	assert.Equal(t, 3, len(client.Actions()))
+}
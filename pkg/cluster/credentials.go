@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// TokenKey is the key of the bearer token in the credentials secret of a Cluster
+	TokenKey = "token"
+	// CAKey is the key of the CA bundle in the credentials secret, the same as the service account token secrets
+	CAKey = "ca.crt"
+	// CertKey and KeyKey are the keys of the client certificate and key in the credentials secret, the same as
+	// the kubernetes.io/tls secrets
+	CertKey = "tls.crt"
+	KeyKey  = "tls.key"
+	// KubeConfigKey is the key of a kubeconfig in the credentials secret. The credentials and CA of it's current
+	// context are used, including the exec plugins
+	KubeConfigKey = "kubeconfig"
+)
+
+// ParseCredentials reads the credentials of a cluster from the data of it's credentials secret.
+// The CA bundle in the secret is only used if info.CAData is empty, the one in Cluster resource wins;
+// so is the server of the kubeconfig.
+func ParseCredentials(info *Info, data map[string][]byte) error {
+	if kc, ok := data[KubeConfigKey]; ok {
+		cfg, err := clientcmd.RESTConfigFromKubeConfig(kc)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", KubeConfigKey, err.Error())
+		}
+		if info.Endpoint == "" {
+			info.Endpoint = cfg.Host
+		}
+		if len(info.CAData) == 0 {
+			info.CAData = cfg.CAData
+		}
+		info.Token = cfg.BearerToken
+		info.CertData = cfg.CertData
+		info.KeyData = cfg.KeyData
+		info.ExecProvider = cfg.ExecProvider
+		info.Insecure = info.Insecure || cfg.Insecure
+	}
+
+	if ca, ok := data[CAKey]; ok && len(info.CAData) == 0 {
+		info.CAData = ca
+	}
+	if token, ok := data[TokenKey]; ok {
+		// why is there a new line.
+		info.Token = strings.TrimSuffix(string(token), "\n")
+	}
+
+	cert, hasCert := data[CertKey]
+	key, hasKey := data[KeyKey]
+	if hasCert != hasKey {
+		return fmt.Errorf("both %s and %s are required for certificate authentication", CertKey, KeyKey)
+	}
+	if hasCert {
+		info.CertData = cert
+		info.KeyData = key
+	}
+
+	if info.Token == "" && len(info.CertData) == 0 && info.ExecProvider == nil {
+		return fmt.Errorf("no credentials found, one of %s, %s/%s or %s is required", TokenKey, CertKey, KeyKey, KubeConfigKey)
+	}
+	return nil
+}
+
+// CheckCredentials fails fast if the token of the cluster is a JWT and has expired, instead of the
+// Unauthorized error from the apiserver. The signature is not verified, that's the apiserver's job.
+func (i *Info) CheckCredentials() error {
+	expiry, ok := getTokenExpiry(i.Token)
+	if ok && time.Now().After(expiry) {
+		return fmt.Errorf("the token of cluster %s has expired at %s, please update the credentials secret of the Cluster",
+			i.GetName(), expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// getTokenExpiry returns the exp claim of a JWT token. Tokens which are not JWTs or have no exp claim,
+// like the legacy service account tokens, never expire.
+func getTokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// IsUnauthorized checks if the error is caused by invalid credentials, like a revoked token or an
+// expired client certificate
+func IsUnauthorized(err error) bool {
+	if err == nil {
+		return false
+	}
+	if apierrors.IsUnauthorized(errors.Cause(err)) {
+		return true
+	}
+	// some errors are formatted as strings by helm
+	return strings.Contains(err.Error(), "the server has asked for the client to provide credentials")
+}
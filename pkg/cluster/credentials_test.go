@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gsamokovarov/assert"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority-data: Y2E=
+users:
+- name: test
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws-iam-authenticator
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+
+func TestParseCredentials(t *testing.T) {
+	info := &Info{Endpoint: "https://1.1.1.1:6443"}
+	err := ParseCredentials(info, map[string][]byte{"token": []byte("abc\n"), "ca.crt": []byte("ca")})
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", info.Token)
+	assert.Equal(t, []byte("ca"), info.CAData)
+
+	// the CA bundle of the Cluster wins
+	info = &Info{CAData: []byte("bundle")}
+	err = ParseCredentials(info, map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key"), "ca.crt": []byte("ca")})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bundle"), info.CAData)
+	assert.Equal(t, []byte("cert"), info.CertData)
+
+	err = ParseCredentials(&Info{}, map[string][]byte{"tls.crt": []byte("cert")})
+	assert.NotNil(t, err)
+
+	err = ParseCredentials(&Info{}, map[string][]byte{"ca.crt": []byte("ca")})
+	assert.NotNil(t, err)
+
+	info = &Info{}
+	err = ParseCredentials(info, map[string][]byte{"kubeconfig": []byte(testKubeConfig)})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.0.0.1:6443", info.Endpoint)
+	assert.Equal(t, []byte("ca"), info.CAData)
+	assert.Equal(t, "aws-iam-authenticator", info.ExecProvider.Command)
+	assert.False(t, info.Insecure)
+}
+
+func TestCheckCredentials(t *testing.T) {
+	jwt := func(exp int64) string {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"captain","exp":%d}`, exp)))
+		return "eyJhbGciOiJSUzI1NiJ9." + payload + ".sig"
+	}
+
+	assert.NotNil(t, (&Info{Token: jwt(time.Now().Add(-time.Hour).Unix())}).CheckCredentials())
+	assert.Nil(t, (&Info{Token: jwt(time.Now().Add(time.Hour).Unix())}).CheckCredentials())
+	assert.Nil(t, (&Info{Token: "legacy-token"}).CheckCredentials())
+}
+
+func TestIsUnauthorized(t *testing.T) {
+	err := errors.Wrap(apierrors.NewUnauthorized("token expired"), "UPGRADE FAILED")
+	assert.True(t, IsUnauthorized(err))
+	assert.False(t, IsUnauthorized(errors.New("connection refused")))
+	assert.False(t, IsUnauthorized(nil))
+}
@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// discoveryTTL is how long the cached discovery info of a cluster lives. The cache is also invalidated
+// by helm after the CRDs of a chart are installed
+var discoveryTTL = 10 * time.Minute
+
+// clientCache holds the discovery client and rest mapper of a cluster, they are shared by all the
+// syncs to this cluster
+type clientCache struct {
+	// config is the rest config the cache was created from, if it's changed, the cache is rebuilt
+	config *rest.Config
+
+	discovery discovery.CachedDiscoveryInterface
+	mapper    meta.RESTMapper
+
+	lock sync.Mutex
+	// refreshed is the time the discovery cache was invalidated last time
+	refreshed time.Time
+}
+
+var (
+	clientCaches     = map[string]*clientCache{}
+	clientCachesLock sync.Mutex
+)
+
+// getClientCache returns the client cache of a cluster, create a new one if not exist or the
+// rest config changed
+func getClientCache(name string, cfg *rest.Config) (*clientCache, error) {
+	clientCachesLock.Lock()
+	defer clientCachesLock.Unlock()
+
+	cache, ok := clientCaches[name]
+	if ok && isConfigEqual(cache.config, cfg) {
+		return cache, nil
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cached := memory.NewMemCacheClient(dc)
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cached)
+	cache = &clientCache{
+		config:    cfg,
+		discovery: cached,
+		mapper:    restmapper.NewShortcutExpander(mapper, cached),
+		refreshed: time.Now(),
+	}
+	clientCaches[name] = cache
+	return cache, nil
+}
+
+// EvictClientCache drops the client cache of a deleted cluster
+func EvictClientCache(name string) {
+	clientCachesLock.Lock()
+	defer clientCachesLock.Unlock()
+	delete(clientCaches, name)
+}
+
+// expire invalidates the discovery cache if it's older than the ttl
+func (c *clientCache) expire() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if time.Since(c.refreshed) > discoveryTTL {
+		c.discovery.Invalidate()
+		c.refreshed = time.Now()
+	}
+}
+
+// isConfigEqual checks if the server and credentials of the rest configs are the same
+func isConfigEqual(a, b *rest.Config) bool {
+	return a.Host == b.Host &&
+		a.BearerToken == b.BearerToken &&
+		a.BearerTokenFile == b.BearerTokenFile &&
+		reflect.DeepEqual(a.ExecProvider, b.ExecProvider) &&
+		a.Insecure == b.Insecure &&
+		string(a.CAData) == string(b.CAData) &&
+		string(a.CertData) == string(b.CertData) &&
+		string(a.KeyData) == string(b.KeyData)
+}
+
+// restClientGetter is a genericclioptions.RESTClientGetter built from a cluster Info in memory, no kubeconfig
+// file is needed
+type restClientGetter struct {
+	config    *rest.Config
+	namespace string
+	cache     *clientCache
+}
+
+// ToRESTClientGetter generate a RESTClientGetter from cluster info, the default namespace is info.Namespace.
+// The discovery client and rest mapper are cached per cluster.
+func (i *Info) ToRESTClientGetter() (genericclioptions.RESTClientGetter, error) {
+	cfg := i.ToRestConfig()
+	cache, err := getClientCache(i.Name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cache.expire()
+	return &restClientGetter{
+		config:    cfg,
+		namespace: i.Namespace,
+		cache:     cache,
+	}, nil
+}
+
+// ToRESTConfig returns a copy of the rest config, the caller may modify it
+func (g *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(g.config), nil
+}
+
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	return g.cache.discovery, nil
+}
+
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	return g.cache.mapper, nil
+}
+
+// ToRawKubeConfigLoader returns an in memory kubeconfig with only one context, which is generated from
+// the rest config
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["cluster"] = &clientcmdapi.Cluster{
+		Server:                   g.config.Host,
+		InsecureSkipTLSVerify:    g.config.Insecure,
+		CertificateAuthorityData: g.config.CAData,
+	}
+	cfg.AuthInfos["user"] = &clientcmdapi.AuthInfo{
+		Token:                 g.config.BearerToken,
+		TokenFile:             g.config.BearerTokenFile,
+		ClientCertificateData: g.config.CertData,
+		ClientKeyData:         g.config.KeyData,
+		Exec:                  g.config.ExecProvider,
+	}
+	cfg.Contexts["context"] = &clientcmdapi.Context{
+		Cluster:   "cluster",
+		AuthInfo:  "user",
+		Namespace: g.namespace,
+	}
+	cfg.CurrentContext = "context"
+	return clientcmd.NewDefaultClientConfig(*cfg, &clientcmd.ConfigOverrides{})
+}
+
+// InvalidateDiscovery drops the cached discovery info of the cluster, so the APIs just created, like the
+// CRDs installed by a chart, can be found
+func (i *Info) InvalidateDiscovery() {
+	clientCachesLock.Lock()
+	cache, ok := clientCaches[i.Name]
+	clientCachesLock.Unlock()
+	if !ok {
+		return
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.discovery.Invalidate()
+	cache.refreshed = time.Now()
+}
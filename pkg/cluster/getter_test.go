@@ -0,0 +1,28 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"k8s.io/client-go/rest"
+)
+
+func TestEvictClientCache(t *testing.T) {
+	cfg := &rest.Config{Host: "https://business.example.com"}
+	cache, err := getClientCache("business", cfg)
+	assert.Nil(t, err)
+	cached, err := getClientCache("business", rest.CopyConfig(cfg))
+	assert.Nil(t, err)
+	assert.True(t, cache == cached)
+
+	EvictClientCache("business")
+	clientCachesLock.Lock()
+	_, ok := clientCaches["business"]
+	clientCachesLock.Unlock()
+	assert.False(t, ok)
+
+	cached, err = getClientCache("business", cfg)
+	assert.Nil(t, err)
+	assert.False(t, cache == cached)
+	EvictClientCache("business")
+}
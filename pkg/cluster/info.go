@@ -1,9 +1,12 @@
 package cluster
 
 import (
+	"fmt"
+
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/cluster-registry/pkg/apis/clusterregistry/v1alpha1"
 	"k8s.io/cluster-registry/pkg/client/clientset/versioned"
 	"k8s.io/klog"
@@ -22,35 +25,79 @@ type Info struct {
 	Endpoint string
 	// Token is a admin token , it should have all the access to the cluster
 	Token string
+	// TokenFile is the file contains the token, it's read when the token is empty. Only used by the
+	// in-cluster config, the token file will be rotated
+	TokenFile string
+
+	// CAData is the PEM encoded CA bundle to verify the certificate of the apiserver. If empty, the
+	// system roots are used
+	CAData []byte
+	// CertData and KeyData are the PEM encoded client certificate and key, if the cluster uses
+	// certificate authentication
+	CertData []byte
+	KeyData  []byte
+	// ExecProvider is the exec plugin to get the credentials, from a kubeconfig
+	ExecProvider *clientcmdapi.ExecConfig
+	// Insecure skips the verification of the apiserver's certificate. Never use it for production
+	Insecure bool
 
 	// Namespace the namespace which the chart will be installed to
 	Namespace string
+
+	// Labels are the labels of the Cluster resource
+	Labels map[string]string
+	// Alias is the name users know the cluster by, if it's different from Name. The default cluster
+	// uses the global cluster name
+	Alias string
+}
+
+// GetName returns the name users know the cluster by
+func (i *Info) GetName() string {
+	if i.Alias != "" {
+		return i.Alias
+	}
+	return i.Name
 }
 
-//GetContext is the context name for this cluster, this name format is generated from k8s code
-func (i *Info) GetContext() string {
-	return i.Name + "@" + i.Name
+// String implements fmt.Stringer, the token is never printed
+func (i Info) String() string {
+	return fmt.Sprintf("{Name:%s Endpoint:%s Namespace:%s}", i.Name, i.Endpoint, i.Namespace)
 }
 
 //ToRestConfig generate rest.Config from cluster info.
 func (i *Info) ToRestConfig() *rest.Config {
 	return &rest.Config{
-		Host:        i.Endpoint,
-		BearerToken: i.Token,
+		Host:            i.Endpoint,
+		BearerToken:     i.Token,
+		BearerTokenFile: i.TokenFile,
+		ExecProvider:    i.ExecProvider,
 		TLSClientConfig: rest.TLSClientConfig{
-			Insecure: true,
+			Insecure: i.Insecure,
+			CAData:   i.CAData,
+			CertData: i.CertData,
+			KeyData:  i.KeyData,
 		},
 	}
 }
 
 //RestConfigToCluster generate a cluster Info from a rest config
-// This method and the Info.ToRestConfig both only support bearer token for now
-// luckily, the in-cluster rest config also use bearer token
+// The CA and client certificate files are loaded, so the Info can be copied to anywhere
 func RestConfigToCluster(config *rest.Config, generatedName string) *Info {
+	cfg := rest.CopyConfig(config)
+	if err := rest.LoadTLSFiles(cfg); err != nil {
+		klog.Warningf("load tls files of rest config error: %s", err.Error())
+	}
+
 	var i Info
-	i.Token = config.BearerToken
-	i.Endpoint = config.Host
+	i.Token = cfg.BearerToken
+	i.TokenFile = cfg.BearerTokenFile
+	i.Endpoint = cfg.Host
 	i.Name = generatedName
+	i.CAData = cfg.CAData
+	i.CertData = cfg.CertData
+	i.KeyData = cfg.KeyData
+	i.ExecProvider = cfg.ExecProvider
+	i.Insecure = cfg.Insecure
 	return &i
 }
 
@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"time"
 
 	"github.com/alauda/captain/pkg/util"
 
@@ -39,8 +40,74 @@ type Options struct {
 	// run in-cluster mode). Hope there will be a better way in the feature.
 	GlobalClusterName string
 
+	// InsecureSkipTLSVerifyWithoutCA skips the TLS verification of the Clusters without a CA bundle, which is
+	// the behavior before the verification was turned on. Deprecated, only to ease the upgrade, will be
+	// removed in the next release
+	InsecureSkipTLSVerifyWithoutCA bool
+
 	// PrintVersion print the version and exist
 	PrintVersion bool
+
+	// ChartCacheDir is the dir to cache chart archives and repo indexes, usually a persistent volume.
+	// If empty, charts will be downloaded every time
+	ChartCacheDir string
+
+	// ChartProxyBindAddress is the bind address of the chart proxy server, which serves all the
+	// ChartRepos as helm repositories from the chart cache. Requires ChartCacheDir
+	ChartProxyBindAddress string
+
+	// ChartProxyAuthSecret is the name of the secret in the ChartRepo namespace, which contains the basic auth
+	// (username and password) or bearer token (token) required by the chart proxy. If empty, only the public
+	// ChartRepos are served
+	ChartProxyAuthSecret string
+
+	// EnableChartUploadAPI enables the api to upload and delete charts of the local ChartRepos, requires
+	// ChartProxyAuthSecret
+	EnableChartUploadAPI bool
+
+	// DefaultLocalRepo is the local ChartRepo of the upload api paths without a repo, like /api/charts
+	DefaultLocalRepo string
+
+	// DecryptionKeySecret is the name of the secret in the ChartRepo namespace (usually the captain namespace),
+	// which contains the age or PGP keys to decrypt values encrypted by sops
+	DecryptionKeySecret string
+
+	// RedactKeyPatterns are the comma separated patterns of the values keys whose values are sensitive,
+	// they will be masked in logs and events
+	RedactKeyPatterns string
+
+	// HelmRequestWorkers is the number of workers for the HelmRequests in the global cluster
+	HelmRequestWorkers int
+	// ChartRepoWorkers is the number of workers for the ChartRepos
+	ChartRepoWorkers int
+	// ClusterWorkers is the number of workers for the HelmRequests in each of the other clusters
+	ClusterWorkers int
+	// UnreachableClusterDeletionTimeout is how long a deleting HelmRequest waits for it's unreachable clusters,
+	// then the releases in them are left as they are. 0 means forever
+	UnreachableClusterDeletionTimeout time.Duration
+	// MaxConcurrentHelmOperations limits the number of helm installs, upgrades and uninstalls running at
+	// the same time across all the workers, 0 means no limit
+	MaxConcurrentHelmOperations int
+
+	// RateLimiterBaseDelay and RateLimiterMaxDelay are the backoff of a failed item in the work queues,
+	// which doubles on every failure
+	RateLimiterBaseDelay time.Duration
+	RateLimiterMaxDelay  time.Duration
+	// RateLimiterQPS and RateLimiterBurst are the overall limit of the retries of each work queue
+	RateLimiterQPS   float64
+	RateLimiterBurst int
+
+	// EnableSharding lets all the replicas work at the same time, each one handles a part of the clusters or
+	// namespaces, instead of only the leader
+	EnableSharding bool
+	// ShardBy is what the HelmRequests are sharded by, cluster or namespace
+	ShardBy string
+	// ShardLeaseNamespace is the namespace of the Leases of the replicas, default to ChartRepoNamespace
+	ShardLeaseNamespace string
+	// ShardLeaseDuration is how long a replica is considered alive after it's Lease is renewed
+	ShardLeaseDuration time.Duration
+	// ShardIdentity is the unique name of this replica, default to the POD_NAME env or the hostname
+	ShardIdentity string
 }
 
 func (opt *Options) setDefaults() {
@@ -65,6 +132,8 @@ func (opt *Options) BindFlags() {
 		"The namespace where all the ChartRepo resource lives in")
 	flag.StringVar(&opt.GlobalClusterName, "global-cluster-name", "global",
 		"The name of the global cluster resource")
+	flag.BoolVar(&opt.InsecureSkipTLSVerifyWithoutCA, "insecure-skip-tls-verify-without-ca", false,
+		"Deprecated: skip the TLS verification of the Clusters without a CA bundle as before, will be removed in the next release")
 	// EnableLeaderElection decide if we should enable leader election
 	// this flag is mainly used to enable local test. If enabled, the controller will also
 	// do a simple check to see if it's running in a kubernetes cluster. If passed,
@@ -78,5 +147,49 @@ func (opt *Options) BindFlags() {
 
 	flag.StringVar(&opt.MetricsBindAddress, "metrics-bind-address", ":6060",
 		"Setup bind address for metrics server, use \"\" to disable it")
+	flag.StringVar(&opt.ChartCacheDir, "chart-cache-dir", "",
+		"The dir to cache charts and repo indexes, use \"\" to disable chart cache")
+	flag.StringVar(&opt.ChartProxyBindAddress, "chart-proxy-bind-address", "",
+		"Setup bind address for chart proxy server, use \"\" to disable it. Requires chart-cache-dir")
+	flag.StringVar(&opt.ChartProxyAuthSecret, "chart-proxy-auth-secret", "",
+		"The secret in chartrepo-namespace which contains the credentials required by the chart proxy, use \"\" to only serve public repos")
+	flag.BoolVar(&opt.EnableChartUploadAPI, "enable-chart-upload-api", false,
+		"Enable the api to upload and delete charts of the local repos. Requires chart-proxy-auth-secret")
+	flag.StringVar(&opt.DefaultLocalRepo, "default-local-repo", "local",
+		"The local repo of the upload api paths without a repo, like /api/charts")
+	flag.StringVar(&opt.DecryptionKeySecret, "decryption-key-secret", "captain-decryption-keys",
+		"The secret in chartrepo-namespace which contains the keys to decrypt values encrypted by sops")
+	flag.StringVar(&opt.RedactKeyPatterns, "redact-key-patterns", "password,token,key",
+		"Comma separated patterns of the values keys whose values will be masked in logs and events, matched case-insensitively")
+
+	flag.IntVar(&opt.HelmRequestWorkers, "helmrequest-workers", 2,
+		"The number of workers for the HelmRequests in the global cluster")
+	flag.IntVar(&opt.ChartRepoWorkers, "chartrepo-workers", 2,
+		"The number of workers for the ChartRepos")
+	flag.IntVar(&opt.ClusterWorkers, "cluster-workers", 2,
+		"The number of workers for the HelmRequests in each of the other clusters")
+	flag.IntVar(&opt.MaxConcurrentHelmOperations, "max-concurrent-helm-operations", 0,
+		"The max number of helm installs, upgrades and uninstalls running at the same time, 0 means no limit")
+	flag.DurationVar(&opt.UnreachableClusterDeletionTimeout, "unreachable-cluster-deletion-timeout", 24*time.Hour,
+		"How long a deleting HelmRequest waits for it's unreachable clusters, then the releases in them are left, 0 means forever")
+	flag.DurationVar(&opt.RateLimiterBaseDelay, "rate-limiter-base-delay", 5*time.Millisecond,
+		"The delay before retrying a failed item for the first time, doubled on every failure")
+	flag.DurationVar(&opt.RateLimiterMaxDelay, "rate-limiter-max-delay", 1000*time.Second,
+		"The max delay before retrying a failed item")
+	flag.Float64Var(&opt.RateLimiterQPS, "rate-limiter-qps", 10,
+		"The overall number of retries per second of each work queue")
+	flag.IntVar(&opt.RateLimiterBurst, "rate-limiter-burst", 100,
+		"The overall burst of retries of each work queue")
+
+	flag.BoolVar(&opt.EnableSharding, "enable-sharding", false,
+		"Enable sharding, all the replicas work on their own shards instead of only the leader")
+	flag.StringVar(&opt.ShardBy, "shard-by", "cluster",
+		"What the HelmRequests are sharded by, cluster or namespace")
+	flag.StringVar(&opt.ShardLeaseNamespace, "shard-lease-namespace", "",
+		"The namespace of the Leases of the replicas, default to chartrepo-namespace")
+	flag.DurationVar(&opt.ShardLeaseDuration, "shard-lease-duration", 30*time.Second,
+		"How long a replica is considered alive after it's Lease is renewed")
+	flag.StringVar(&opt.ShardIdentity, "shard-identity", "",
+		"The unique name of this replica, default to the POD_NAME env or the hostname")
 
 }
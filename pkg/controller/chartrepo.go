@@ -18,6 +18,11 @@ import (
 
 // updateChartRepoStatus update ChartRepo's status
 func (c *Controller) updateChartRepoStatus(cr *v1alpha1.ChartRepo, phase v1alpha1.ChartRepoPhase, reason string) {
+	// the status is updated by the replica who owns the ChartRepo
+	if !c.ownsChartRepo(cr) {
+		return
+	}
+
 	//cr = cr.DeepCopy()
 	//cr.Status.Phase = phase
 	//cr.Status.Reason = reason
@@ -65,6 +70,35 @@ func (c *Controller) syncChartRepo(obj interface{}) {
 
 	cr := obj.(*v1alpha1.ChartRepo)
 
+	if isLocalChartRepo(cr) {
+		if err := c.addLocalChartRepo(cr); err != nil {
+			c.updateChartRepoStatus(cr, v1alpha1.ChartRepoFailed, err.Error())
+			return
+		}
+	} else if err := c.addRemoteChartRepo(cr); err != nil {
+		c.updateChartRepoStatus(cr, v1alpha1.ChartRepoFailed, err.Error())
+		return
+	}
+
+	// every replica adds the repo to find the charts, but only the owner creates the Chart resources
+	if !c.ownsChartRepo(cr) {
+		klog.V(4).Infof("chartrepo %s is not in the shards of this replica, skip creating charts", cr.GetName())
+		return
+	}
+
+	if err := c.createCharts(cr); err != nil {
+		c.updateChartRepoStatus(cr, v1alpha1.ChartRepoFailed, err.Error())
+		return
+	}
+
+	c.updateChartRepoStatus(cr, v1alpha1.ChartRepoSynced, "")
+	klog.Info("synced chartrepo: ", cr.GetName())
+	return
+
+}
+
+// addRemoteChartRepo add a ChartRepo to helm and download it's index
+func (c *Controller) addRemoteChartRepo(cr *v1alpha1.ChartRepo) error {
 	var username string
 	var password string
 
@@ -75,9 +109,8 @@ func (c *Controller) syncChartRepo(obj interface{}) {
 		}
 		secret, err := c.kubeClient.CoreV1().Secrets(ns).Get(cr.Spec.Secret.Name, v1.GetOptions{})
 		if err != nil {
-			c.updateChartRepoStatus(cr, v1alpha1.ChartRepoFailed, err.Error())
 			klog.Error("get secret for chartrepo error: ", err)
-			return
+			return err
 		}
 		data := secret.Data
 		username = string(data["username"])
@@ -85,20 +118,7 @@ func (c *Controller) syncChartRepo(obj interface{}) {
 
 	}
 
-	if err := helm.AddBasicAuthRepository(cr.GetName(), cr.Spec.URL, username, password); err != nil {
-		c.updateChartRepoStatus(cr, v1alpha1.ChartRepoFailed, err.Error())
-		return
-	}
-
-	if err := c.createCharts(cr); err != nil {
-		c.updateChartRepoStatus(cr, v1alpha1.ChartRepoFailed, err.Error())
-		return
-	}
-
-	c.updateChartRepoStatus(cr, v1alpha1.ChartRepoSynced, "")
-	klog.Info("synced chartrepo: ", cr.GetName())
-	return
-
+	return helm.AddBasicAuthRepository(cr.GetName(), cr.Spec.URL, username, password)
 }
 
 // createCharts create charts resource for a repo
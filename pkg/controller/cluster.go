@@ -2,9 +2,9 @@ package controller
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/util"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cluster-registry/pkg/apis/clusterregistry/v1alpha1"
@@ -53,29 +53,55 @@ func (c *Controller) getAllClusters() ([]*cluster.Info, error) {
 	return info, nil
 }
 
+// parseClusterInfo reads the endpoint, CA bundle and credentials of a Cluster. The apiserver's certificate is
+// verified unless the Cluster is annotated with insecure-skip-tls-verify, or it has no CA bundle and the
+// deprecated insecure-skip-tls-verify-without-ca flag is set.
 func (c *Controller) parseClusterInfo(cr *v1alpha1.Cluster) (*cluster.Info, error) {
 	var info cluster.Info
 	info.Name = cr.GetName()
+	info.Labels = cr.GetLabels()
 	eps := cr.Spec.KubernetesAPIEndpoints.ServerEndpoints
 	if len(eps) > 0 {
 		info.Endpoint = eps[0].ServerAddress
 	}
+	info.CAData = cr.Spec.KubernetesAPIEndpoints.CABundle
+	info.Insecure = util.IsAnnotationTrue(cr, util.InsecureSkipTLSVerifyKey)
 
+	if cr.Spec.AuthInfo.Controller == nil {
+		return nil, fmt.Errorf("no controller auth info for cluster: %s", cr.Name)
+	}
 	ns := cr.Spec.AuthInfo.Controller.Namespace
 	secretName := cr.Spec.AuthInfo.Controller.Name
-	// get token
+	// get credentials
 	sec, err := c.kubeClient.CoreV1().Secrets(ns).Get(secretName, v1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	data, ok := sec.Data["token"]
-	if ok {
-		// why is there a new line.
-		info.Token = strings.TrimSuffix(string(data), "\n")
-		return &info, nil
+	if err := cluster.ParseCredentials(&info, sec.Data); err != nil {
+		return nil, fmt.Errorf("get credentials error for cluster %s: %s", cr.Name, err.Error())
+	}
+	if info.Endpoint == "" {
+		return nil, fmt.Errorf("no server endpoint for cluster: %s", cr.Name)
 	}
-	return nil, fmt.Errorf(" get token error for cluster: %s", cr.Name)
+	if len(info.CAData) == 0 && c.clusterConfig.insecureWithoutCA {
+		klog.Warningf("cluster %s has no CA bundle, the tls verification is skipped by the deprecated "+
+			"insecure-skip-tls-verify-without-ca flag, please add the CA bundle", cr.Name)
+		info.Insecure = true
+	}
+	if info.Insecure {
+		klog.Warningf("tls verification of cluster %s is skipped", cr.Name)
+	}
+	return &info, nil
+}
+
+// clusterError adds hints to the errors caused by the credentials of a cluster
+func clusterError(info *cluster.Info, err error) error {
+	if cluster.IsUnauthorized(err) {
+		return fmt.Errorf("unauthorized to cluster %s, the credentials may be expired or revoked, please update the "+
+			"credentials secret of the Cluster: %s", info.GetName(), err.Error())
+	}
+	return err
 }
 
 // getClusterInfo get info about one single cluster.
@@ -83,7 +109,16 @@ func (c *Controller) parseClusterInfo(cr *v1alpha1.Cluster) (*cluster.Info, erro
 func (c *Controller) getClusterInfo(name string) (*cluster.Info, error) {
 	if name == "" {
 		klog.V(2).Info("find empty cluster name, use current.")
-		return cluster.RestConfigToCluster(c.restConfig, cluster.DefaultClusterName), nil
+		info := cluster.RestConfigToCluster(c.restConfig, cluster.DefaultClusterName)
+		info.Alias = c.clusterConfig.globalClusterName
+		// the labels of the global Cluster resource, if there is one
+		if c.clusterConfig.globalClusterName == "" {
+			return info, nil
+		}
+		if global, err := c.getClusterInfo(c.clusterConfig.globalClusterName); err == nil {
+			info.Labels = global.Labels
+		}
+		return info, nil
 	}
 
 	data, ok := c.ClusterCache.Get(name)
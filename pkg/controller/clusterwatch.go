@@ -10,6 +10,7 @@ import (
 	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
 	hrScheme "github.com/alauda/helm-crds/pkg/client/clientset/versioned/scheme"
 	informers "github.com/alauda/helm-crds/pkg/client/informers/externalversions"
+	listers "github.com/alauda/helm-crds/pkg/client/listers/app/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -58,11 +59,19 @@ func (c *Controller) initClusterWatches(stopCh <-chan struct{}) error {
 
 		informerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
 		informer := informerFactory.App().V1alpha1().HelmRequests()
+		if err := informer.Informer().AddIndexers(helmRequestIndexers); err != nil {
+			klog.Warningf("add indexers for cluster %s error: %s", cluster.Name, err.Error())
+			continue
+		}
+		recorder := c.createEventRecorder(cluster.Name, coreClient)
+		c.clusterLock.Lock()
 		c.clusterHelmRequestListers[cluster.Name] = informer.Lister()
 		c.clusterHelmRequestSynced[cluster.Name] = informer.Informer().HasSynced
-		c.clusterWorkQueues[cluster.Name] = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), cluster.Name)
+		c.clusterHelmRequestIndexers[cluster.Name] = informer.Informer().GetIndexer()
+		c.clusterWorkQueues[cluster.Name] = workqueue.NewNamedRateLimitingQueue(c.workerOptions.newRateLimiter(), cluster.Name)
 		c.clusterClients[cluster.Name] = client
-		c.clusterRecorders[cluster.Name] = c.createEventRecorder(cluster.Name, coreClient)
+		c.clusterRecorders[cluster.Name] = recorder
+		c.clusterLock.Unlock()
 
 		// add event handler
 		informer.Informer().AddEventHandler(c.newClusterHelmRequestHandler(cluster.Name))
@@ -75,6 +84,46 @@ func (c *Controller) initClusterWatches(stopCh <-chan struct{}) error {
 
 }
 
+// getClusterWorkQueue returns the work queue of a cluster, nil if the cluster is not watched
+func (c *Controller) getClusterWorkQueue(name string) workqueue.RateLimitingInterface {
+	c.clusterLock.RLock()
+	defer c.clusterLock.RUnlock()
+	return c.clusterWorkQueues[name]
+}
+
+// getClusterNames returns the names of the watched clusters
+func (c *Controller) getClusterNames() []string {
+	c.clusterLock.RLock()
+	defer c.clusterLock.RUnlock()
+	var names []string
+	for name := range c.clusterClients {
+		names = append(names, name)
+	}
+	return names
+}
+
+// getClusterHelmRequestListers returns a copy of the HelmRequest listers of the watched clusters
+func (c *Controller) getClusterHelmRequestListers() map[string]listers.HelmRequestLister {
+	c.clusterLock.RLock()
+	defer c.clusterLock.RUnlock()
+	result := make(map[string]listers.HelmRequestLister, len(c.clusterHelmRequestListers))
+	for name, lister := range c.clusterHelmRequestListers {
+		result[name] = lister
+	}
+	return result
+}
+
+// getClusterHelmRequestIndexers returns a copy of the HelmRequest indexers of the watched clusters
+func (c *Controller) getClusterHelmRequestIndexers() map[string]cache.Indexer {
+	c.clusterLock.RLock()
+	defer c.clusterLock.RUnlock()
+	result := make(map[string]cache.Indexer, len(c.clusterHelmRequestIndexers))
+	for name, indexer := range c.clusterHelmRequestIndexers {
+		result[name] = indexer
+	}
+	return result
+}
+
 // createEventRecorder create event recoder for a cluster
 // create the recoder manually is easier to user the method provides by controller-runtime.Manager. Maybe?
 // TODO: change all args of cluster to cluster (from `name`)
@@ -99,7 +148,7 @@ func (c *Controller) startAllClustersWatch(stopCh <-chan struct{}) error {
 
 	klog.Info("init cluster helmrequests watches done")
 
-	for k := range c.clusterClients {
+	for _, k := range c.getClusterNames() {
 		if err := c.startClusterWatch(k, stopCh); err != nil {
 			return err
 		}
@@ -119,17 +168,19 @@ func (c *Controller) startClusterWatch(name string, stopCh <-chan struct{}) erro
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync: ", name)
-	if ok := cache.WaitForCacheSync(stopCh, c.clusterHelmRequestSynced[name]); !ok {
+	c.clusterLock.RLock()
+	synced := c.clusterHelmRequestSynced[name]
+	c.clusterLock.RUnlock()
+	if ok := cache.WaitForCacheSync(stopCh, synced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync: %s", name)
 	}
 
-	klog.Info("Starting workers for clusters: ", name)
-	// Launch two workers to process HelmRequest resources
+	klog.Infof("Starting %d workers for cluster: %s", c.workerOptions.clusterWorkers, name)
 	f := func() {
 		c.runClusterWorker(name)
 	}
 
-	for i := 0; i < 2; i++ {
+	for i := 0; i < c.workerOptions.clusterWorkers; i++ {
 		go wait.Until(f, time.Second, stopCh)
 	}
 
@@ -149,7 +200,7 @@ func (c *Controller) runClusterWorker(name string) {
 // processNextWorkItem will read a single work item off the workQueue and
 // attempt to process it, by calling the syncHandler.
 func (c *Controller) processNextClusterWorkItem(name string) bool {
-	queue := c.clusterWorkQueues[name]
+	queue := c.getClusterWorkQueue(name)
 
 	obj, shutdown := queue.Get()
 
@@ -218,7 +269,7 @@ func (c *Controller) enqueueClusterHelmRequest(obj interface{}, name string) {
 	key = fmt.Sprintf("%s/%s", name, key)
 	klog.Infof("enqueue helmrequest: %s", key)
 
-	c.clusterWorkQueues[name].Add(key)
+	c.getClusterWorkQueue(name).Add(key)
 }
 
 func clusterKey(key, name string) string {
@@ -237,26 +288,23 @@ func splitClusterKey(key string) (string, string) {
 	return cluster, k
 }
 
+// deleteClusterHelmRequestHandler is delete handler for HelmRequest in other clusters, the release is deleted
+// by the workers, helm never runs in the handler
 func (c *Controller) deleteClusterHelmRequestHandler(obj interface{}, name string) {
-	var err error
-	var key string
-	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-		utilruntime.HandleError(err)
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	hr, ok := obj.(*alpha1.HelmRequest)
+	if !ok {
 		return
 	}
 
-	hr := obj.(*alpha1.HelmRequest)
-
 	hr = hr.DeepCopy()
 	hr.ClusterName = name
+	c.enqueueDependencies(hr, name)
 
-	err = c.deleteHelmRequest(hr)
-	if err != nil {
-		c.sendFailedDeleteEvent(hr, err)
-		utilruntime.HandleError(err)
-		c.clusterWorkQueues[name].AddRateLimited(clusterKey(key, name))
-	} else {
-		c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, SuccessfulDelete,
-			fmt.Sprintf("Deleted HelmRequest: %s", hr.GetName()))
-	}
+	// recorded by all the replicas, the shards may be moved before the release is deleted
+	key := clusterKey(fmt.Sprintf("%s/%s", hr.GetNamespace(), hr.GetName()), name)
+	c.deleted.add(key, hr)
+	c.getClusterWorkQueue(name).Add(key)
 }
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+const (
+	// DependenciesMet means all the dependencies of the HelmRequest are synced to the target clusters
+	DependenciesMet = "DependenciesMet"
+)
+
+// HelmRequestCondition describes the state of a HelmRequest at a certain point.
+// The HelmRequestStatus type has no conditions field, so they are stored in .status.conditions by
+// merge patch and read back from the raw object.
+type HelmRequestCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// helmRequestConditions caches the conditions of the HelmRequests being synced, so they are read from the
+// apiserver at most once per sync, and kept up to date in memory by setHelmRequestCondition. The items are
+// removed when the sync is done.
+type helmRequestConditions struct {
+	lock  sync.Mutex
+	items map[types.UID][]HelmRequestCondition
+}
+
+func (h *helmRequestConditions) get(uid types.UID) ([]HelmRequestCondition, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	item, ok := h.items[uid]
+	return append([]HelmRequestCondition(nil), item...), ok
+}
+
+func (h *helmRequestConditions) set(uid types.UID, conditions []HelmRequestCondition) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.items == nil {
+		h.items = map[types.UID][]HelmRequestCondition{}
+	}
+	h.items[uid] = append([]HelmRequestCondition(nil), conditions...)
+}
+
+func (h *helmRequestConditions) forget(uid types.UID) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.items, uid)
+}
+
+// getHelmRequestConditions get the current conditions of a HelmRequest, from the cache or the apiserver
+func (c *Controller) getHelmRequestConditions(hr *v1alpha1.HelmRequest) ([]HelmRequestCondition, error) {
+	if conditions, ok := c.conditions.get(hr.GetUID()); ok {
+		return conditions, nil
+	}
+
+	data, err := c.getAppClient(hr).AppV1alpha1().RESTClient().Get().
+		Namespace(hr.GetNamespace()).Resource("helmrequests").Name(hr.GetName()).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var obj struct {
+		Status struct {
+			Conditions []HelmRequestCondition `json:"conditions,omitempty"`
+		} `json:"status,omitempty"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	c.conditions.set(hr.GetUID(), obj.Status.Conditions)
+	return obj.Status.Conditions, nil
+}
+
+// setHelmRequestCondition add or update a condition of the HelmRequest. Nothing will be patched if
+// the condition is not changed.
+func (c *Controller) setHelmRequestCondition(hr *v1alpha1.HelmRequest, condition HelmRequestCondition) error {
+	conditions, err := c.getHelmRequestConditions(hr)
+	if err != nil {
+		return err
+	}
+
+	condition.LastTransitionTime = metav1.Now()
+	found := false
+	for i, item := range conditions {
+		if item.Type != condition.Type {
+			continue
+		}
+		found = true
+		if item.Status == condition.Status {
+			condition.LastTransitionTime = item.LastTransitionTime
+		}
+		if reflect.DeepEqual(item, condition) {
+			return nil
+		}
+		conditions[i] = condition
+	}
+	if !found {
+		conditions = append(conditions, condition)
+	}
+
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": conditions,
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	klog.V(4).Infof("set condition %s of helmrequest %s to %s: %s", condition.Type, hr.GetName(), condition.Status, condition.Message)
+	_, err = c.getAppClient(hr).AppV1alpha1().HelmRequests(hr.GetNamespace()).Patch(hr.GetName(), types.MergePatchType, data, "status")
+	if err != nil {
+		c.conditions.forget(hr.GetUID())
+		return err
+	}
+	c.conditions.set(hr.GetUID(), conditions)
+	return nil
+}
+
+// isHelmRequestConditionTrue checks if the HelmRequest has the condition and it's status is True
+func (c *Controller) isHelmRequestConditionTrue(hr *v1alpha1.HelmRequest, conditionType string) bool {
+	conditions, err := c.getHelmRequestConditions(hr)
+	if err != nil {
+		klog.Warningf("get conditions of helmrequest %s error: %s", hr.GetName(), err.Error())
+		return false
+	}
+	for _, item := range conditions {
+		if item.Type == conditionType {
+			return item.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
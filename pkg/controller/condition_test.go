@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestHelmRequestConditions(t *testing.T) {
+	var h helmRequestConditions
+	_, ok := h.get("a")
+	assert.False(t, ok)
+
+	conditions := []HelmRequestCondition{{Type: ClusterUnreachable, Status: corev1.ConditionTrue}}
+	h.set("a", conditions)
+	result, ok := h.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, conditions, result)
+
+	// the cached ones are copies
+	result[0].Status = corev1.ConditionFalse
+	result, _ = h.get("a")
+	assert.Equal(t, corev1.ConditionTrue, result[0].Status)
+
+	// cached empty conditions are still cached
+	h.set("b", nil)
+	_, ok = h.get("b")
+	assert.True(t, ok)
+
+	h.forget("a")
+	_, ok = h.get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// Conflicts means some fields changed by the chart are also changed by others in the target clusters
+	Conflicts = "Conflicts"
+
+	// FieldConflict is the reason of the events for the conflicts
+	FieldConflict = "FieldConflict"
+)
+
+// syncResult is the problems found when syncing a HelmRequest, they are set as conditions
+type syncResult struct {
+	// warnings are the messages of the deprecated APIs
+	warnings []string
+	// conflicts are the fields changed by both the chart and others
+	conflicts []string
+}
+
+func (r *syncResult) add(other syncResult) {
+	r.warnings = append(r.warnings, other.warnings...)
+	r.conflicts = append(r.conflicts, other.conflicts...)
+}
+
+// recordConflicts records an event for the conflicts, and returns them prefixed with the cluster name
+func (c *Controller) recordConflicts(hr *v1alpha1.HelmRequest, info *cluster.Info, conflicts []string) []string {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, item := range conflicts {
+		messages = append(messages, fmt.Sprintf("cluster %s: %s", info.GetName(), item))
+	}
+	c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, FieldConflict,
+		"fields changed by others: "+strings.Join(messages, "; "))
+	return messages
+}
+
+// setConflictsCondition sets the Conflicts condition with the conflicts. If there is none, the condition
+// is cleared if it exists.
+func (c *Controller) setConflictsCondition(hr *v1alpha1.HelmRequest, conflicts []string) {
+	condition := HelmRequestCondition{
+		Type:   Conflicts,
+		Status: corev1.ConditionFalse,
+	}
+	if len(conflicts) > 0 {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = FieldConflict
+		condition.Message = strings.Join(conflicts, "; ")
+	} else if !c.isHelmRequestConditionTrue(hr, Conflicts) {
+		return
+	}
+
+	if err := c.setHelmRequestCondition(hr, condition); err != nil {
+		klog.Warningf("set condition %s of helmrequest %s error: %s", Conflicts, hr.GetName(), err.Error())
+	}
+}
+
+// setSyncResultConditions sets the conditions of the problems found by the sync
+func (c *Controller) setSyncResultConditions(hr *v1alpha1.HelmRequest, result syncResult) {
+	c.setDeprecatedAPIsCondition(hr, result.warnings)
+	c.setConflictsCondition(hr, result.conflicts)
+}
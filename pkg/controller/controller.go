@@ -18,16 +18,23 @@ package controller
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/alauda/captain/pkg/chartproxy"
 	"github.com/alauda/captain/pkg/config"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/shard"
 	"github.com/alauda/captain/pkg/util"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
@@ -53,6 +60,9 @@ type clusterConfig struct {
 	clusterNamespace string
 
 	globalClusterName string
+
+	// insecureWithoutCA skips the TLS verification of the Clusters without a CA bundle, deprecated
+	insecureWithoutCA bool
 }
 
 // Controller is the controller implementation for HelmRequest resources
@@ -72,10 +82,23 @@ type Controller struct {
 
 	helmRequestLister listers.HelmRequestLister
 	helmRequestSynced cache.InformerSynced
+	// helmRequestIndexer is used to find the dependents of a HelmRequest
+	helmRequestIndexer cache.Indexer
 
 	chartRepoSynced cache.InformerSynced
 	chartRepoLister listers.ChartRepoLister
 
+	// configMapLister and secretLister are used to read the valuesFrom sources, to know if they are changed
+	configMapLister corelisters.ConfigMapLister
+	secretLister    corelisters.SecretLister
+	sourcesSynced   []cache.InformerSynced
+
+	// chartRepoNamespace is the namespace that all the ChartRepo resource lives in
+	chartRepoNamespace string
+
+	// chartCache is the local chart cache, also used as the storage of local ChartRepos. May be nil
+	chartCache *chartproxy.Cache
+
 	// ClusterCache is used to store Cluster resource
 	ClusterCache *commoncache.Cache
 
@@ -93,11 +116,34 @@ type Controller struct {
 
 	// To support multiple cluster, we have to watch all the clusters for HelmRequests
 	// May be we should remove the old field for global cluster...
-	clusterHelmRequestListers map[string]listers.HelmRequestLister
-	clusterHelmRequestSynced  map[string]cache.InformerSynced
-	clusterWorkQueues         map[string]workqueue.RateLimitingInterface
-	clusterClients            map[string]clientset.Interface
-	clusterRecorders          map[string]record.EventRecorder
+	clusterHelmRequestListers  map[string]listers.HelmRequestLister
+	clusterHelmRequestSynced   map[string]cache.InformerSynced
+	clusterHelmRequestIndexers map[string]cache.Indexer
+	clusterWorkQueues          map[string]workqueue.RateLimitingInterface
+	clusterClients             map[string]clientset.Interface
+	clusterRecorders           map[string]record.EventRecorder
+	// clusterLock guards the cluster maps above, they are written by initClusterWatches while the global
+	// informers and workers are already running
+	clusterLock sync.RWMutex
+
+	// clusterHealths are the results of the cluster prober
+	clusterHealths clusterHealths
+	// conditions caches the conditions of the HelmRequests being synced
+	conditions helmRequestConditions
+	// deleted are the HelmRequests removed from the informers, waiting for the workers to delete their releases
+	deleted deletedHelmRequests
+	// unreachableDeletionTimeout is how long a deleting HelmRequest waits for it's unreachable clusters,
+	// 0 means forever
+	unreachableDeletionTimeout time.Duration
+
+	// workerOptions controls the number of workers and the backoff of the work queues
+	workerOptions workerOptions
+	// helmOperations limits the number of concurrent helm operations of all the workers
+	helmOperations helmOperations
+
+	// shards decides the HelmRequests and ChartRepos handled by this replica, nil if sharding is disabled
+	shards  *shard.Manager
+	shardBy string
 }
 
 //NewController create a new controller
@@ -118,11 +164,22 @@ func NewController(mgr manager.Manager, opt *config.Options, stopCh <-chan struc
 		return nil, err
 	}
 
-	// kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
+	workerOptions, err := newWorkerOptions(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the labeled ConfigMaps and Secrets are watched, caching all of them costs too much memory
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Second*30,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = util.ValuesSourceLabel + "=true"
+		}))
 	appInformerFactory := informers.NewSharedInformerFactory(appClient, time.Second*30)
 	chartRepoInformerFactory := informers.NewSharedInformerFactoryWithOptions(appClient, time.Second*30, informers.WithNamespace(opt.ChartRepoNamespace))
 
 	informer := appInformerFactory.App().V1alpha1().HelmRequests()
+	configMapInformer := kubeInformerFactory.Core().V1().ConfigMaps()
+	secretInformer := kubeInformerFactory.Core().V1().Secrets()
 	repoInformer := chartRepoInformerFactory.App().V1alpha1().ChartRepos()
 
 	controller := &Controller{
@@ -132,32 +189,52 @@ func NewController(mgr manager.Manager, opt *config.Options, stopCh <-chan struc
 			clusterNamespace:  opt.ClusterNamespace,
 			clusterClient:     clusterClient,
 			globalClusterName: opt.GlobalClusterName,
+			insecureWithoutCA: opt.InsecureSkipTLSVerifyWithoutCA,
 		},
 		restConfig:         cfg,
+		chartRepoNamespace: opt.ChartRepoNamespace,
 		recorder:           mgr.GetEventRecorderFor(util.ComponentName),
 		helmRequestLister:  informer.Lister(),
 		chartRepoLister:    repoInformer.Lister(),
 		helmRequestSynced:  informer.Informer().HasSynced,
+		helmRequestIndexer: informer.Informer().GetIndexer(),
+		configMapLister:    configMapInformer.Lister(),
+		secretLister:       secretInformer.Lister(),
+		sourcesSynced:      []cache.InformerSynced{configMapInformer.Informer().HasSynced, secretInformer.Informer().HasSynced},
 		chartRepoSynced:    repoInformer.Informer().HasSynced,
-		workQueue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "HelmRequests"),
-		chartRepoWorkQueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ChartRepos"),
+		workQueue:          workqueue.NewNamedRateLimitingQueue(workerOptions.newRateLimiter(), "HelmRequests"),
+		chartRepoWorkQueue: workqueue.NewNamedRateLimitingQueue(workerOptions.newRateLimiter(), "ChartRepos"),
+		workerOptions:      workerOptions,
+		helmOperations:     newHelmOperations(opt.MaxConcurrentHelmOperations),
 		// refresh frequently
 		ClusterCache: commoncache.New(1*time.Minute, 5*time.Minute),
 
 		// only init data structures, start it later
-		clusterHelmRequestListers: make(map[string]listers.HelmRequestLister),
-		clusterHelmRequestSynced:  make(map[string]cache.InformerSynced),
-		clusterWorkQueues:         make(map[string]workqueue.RateLimitingInterface),
-		clusterClients:            make(map[string]clientset.Interface),
-		clusterRecorders:          make(map[string]record.EventRecorder),
+		clusterHelmRequestListers:  make(map[string]listers.HelmRequestLister),
+		clusterHelmRequestSynced:   make(map[string]cache.InformerSynced),
+		clusterHelmRequestIndexers: make(map[string]cache.Indexer),
+		clusterWorkQueues:          make(map[string]workqueue.RateLimitingInterface),
+		clusterClients:             make(map[string]clientset.Interface),
+		clusterRecorders:           make(map[string]record.EventRecorder),
+		shardBy:                    opt.ShardBy,
+	}
+
+	controller.unreachableDeletionTimeout = opt.UnreachableClusterDeletionTimeout
+	controller.shards, err = controller.newShardManager(kubeClient, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := informer.Informer().AddIndexers(helmRequestIndexers); err != nil {
+		return nil, err
 	}
 
 	klog.Info("Setting up event handlers")
 	// Set up an event handler for when HelmRequest resources change
 	informer.Informer().AddEventHandler(controller.newHelmRequestHandler())
 	repoInformer.Informer().AddEventHandler(controller.newChartRepoHandler())
-
-	klog.V(7).Infof("cluster rest config is : %+v", cfg)
+	configMapInformer.Informer().AddEventHandler(controller.newSourceHandler(helm.SourceKindConfigMap))
+	secretInformer.Informer().AddEventHandler(controller.newSourceHandler(helm.SourceKindSecret))
 
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
@@ -165,6 +242,7 @@ func NewController(mgr manager.Manager, opt *config.Options, stopCh <-chan struc
 	// appInformerFactory.Start(stopCh)
 
 	// fuck examples, this should after init controller
+	kubeInformerFactory.Start(stopCh)
 	appInformerFactory.Start(stopCh)
 	chartRepoInformerFactory.Start(stopCh)
 
@@ -190,14 +268,24 @@ func (c *Controller) Start(stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.helmRequestSynced, c.chartRepoSynced); !ok {
+	synced := append([]cache.InformerSynced{c.helmRequestSynced, c.chartRepoSynced}, c.sourcesSynced...)
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
-	klog.Info("Starting workers")
-	// Launch two workers to process HelmRequest resources
-	for i := 0; i < 2; i++ {
+	// the shards should be known before the workers start
+	if c.shards != nil {
+		if err := c.shards.Join(); err != nil {
+			return fmt.Errorf("failed to join the shards: %s", err.Error())
+		}
+	}
+
+	klog.Infof("Starting %d HelmRequest workers and %d ChartRepo workers", c.workerOptions.helmRequestWorkers,
+		c.workerOptions.chartRepoWorkers)
+	for i := 0; i < c.workerOptions.helmRequestWorkers; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	for i := 0; i < c.workerOptions.chartRepoWorkers; i++ {
 		go wait.Until(c.runChartRepoWorker, time.Second, stopCh)
 	}
 
@@ -206,14 +294,29 @@ func (c *Controller) Start(stopCh <-chan struct{}) error {
 		return err
 	}
 
+	// probe the clusters, skip the unreachable ones when sync
+	go c.runClusterProber(stopCh)
+
+	// renew the Lease of this replica, the Lease is deleted on shutdown
+	shardsDone := make(chan struct{})
+	go func() {
+		defer close(shardsDone)
+		if c.shards != nil {
+			c.shards.Run(stopCh)
+		}
+	}()
+
 	klog.Info("Started workers")
 	<-stopCh
 	klog.Info("Shutting down workers")
 
 	// fuck. this bug. shutdown now manually
+	c.clusterLock.RLock()
 	for _, v := range c.clusterWorkQueues {
 		v.ShutDown()
 	}
+	c.clusterLock.RUnlock()
+	<-shardsDone
 
 	return nil
 }
@@ -424,6 +527,8 @@ func (c *Controller) getAppClient(hr *alpha1.HelmRequest) clientset.Interface {
 	if hr.ClusterName == "" {
 		return c.appClientSet
 	} else {
+		c.clusterLock.RLock()
+		defer c.clusterLock.RUnlock()
 		return c.clusterClients[hr.ClusterName]
 	}
 }
@@ -432,6 +537,8 @@ func (c *Controller) getHelmRequestLister(name string) listers.HelmRequestLister
 	if name == "" {
 		return c.helmRequestLister
 	} else {
+		c.clusterLock.RLock()
+		defer c.clusterLock.RUnlock()
 		return c.clusterHelmRequestListers[name]
 	}
 }
@@ -440,6 +547,8 @@ func (c *Controller) getEventRecorder(hr *alpha1.HelmRequest) record.EventRecord
 	if hr.ClusterName == "" {
 		return c.recorder
 	} else {
+		c.clusterLock.RLock()
+		defer c.clusterLock.RUnlock()
 		return c.clusterRecorders[hr.ClusterName]
 	}
 }
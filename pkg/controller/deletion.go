@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	funk "github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// WaitingForDependents is used as the event reason when the deletion of a HelmRequest is blocked by it's dependents
+const WaitingForDependents = "WaitingForDependents"
+
+// dependentsExistError means a HelmRequest cannot be deleted yet because other HelmRequests depend on it
+type dependentsExistError struct {
+	dependents []string
+}
+
+func (e *dependentsExistError) Error() string {
+	return fmt.Sprintf("waiting for dependents to be deleted: %s", strings.Join(e.dependents, ", "))
+}
+
+// isDependentsExistError checks if the error is returned because of the dependents
+func isDependentsExistError(err error) bool {
+	_, ok := err.(*dependentsExistError)
+	return ok
+}
+
+// getDependents get the HelmRequests which depend on this one, from the same cluster
+func (c *Controller) getDependents(hr *v1alpha1.HelmRequest) ([]*v1alpha1.HelmRequest, error) {
+	indexer := c.getHelmRequestIndexer(hr.ClusterName)
+	if indexer == nil {
+		return nil, nil
+	}
+	items, err := indexer.ByIndex(dependentsIndex, fmt.Sprintf("%s/%s", hr.GetNamespace(), hr.GetName()))
+	if err != nil {
+		return nil, err
+	}
+	var result []*v1alpha1.HelmRequest
+	for _, item := range items {
+		result = append(result, item.(*v1alpha1.HelmRequest))
+	}
+	return result, nil
+}
+
+// checkDependentsForDeletion checks if a HelmRequest can be deleted. While other HelmRequests depend on it,
+// it should wait for them to be deleted first, so the resources they need (for example, the CRDs installed
+// by an operator) are still there when they are uninstalled.
+// If the dependents policy is Cascade, the dependents in the same namespace are deleted by captain, otherwise
+// it's up to the user. The dependents in other namespaces are never deleted, they only block the deletion.
+// This is only checked when we still hold the finalizer, when the HelmRequest is gone, there is nothing to wait for.
+func (c *Controller) checkDependentsForDeletion(hr *v1alpha1.HelmRequest) error {
+	if !funk.Contains(hr.Finalizers, util.FinalizerName) {
+		return nil
+	}
+
+	dependents, err := c.getDependents(hr)
+	if err != nil {
+		return err
+	}
+	if len(dependents) == 0 {
+		return nil
+	}
+
+	cascade := util.GetAnnotation(hr, util.DependentsPolicyKey) == util.DependentsPolicyCascade
+	e := &dependentsExistError{}
+	for _, item := range dependents {
+		e.dependents = append(e.dependents, fmt.Sprintf("%s/%s", item.GetNamespace(), item.GetName()))
+		if !cascade || !item.DeletionTimestamp.IsZero() || item.GetNamespace() != hr.GetNamespace() {
+			continue
+		}
+		klog.Infof("cascade delete dependent %s/%s of helmrequest %s", item.GetNamespace(), item.GetName(), hr.GetName())
+		err := c.getAppClient(hr).AppV1alpha1().HelmRequests(item.GetNamespace()).Delete(item.GetName(), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, WaitingForDependents, e.Error())
+	return e
+}
+
+// enqueueDependencies enqueue the dependencies of a deleted HelmRequest immediately, if they are waiting for
+// it's deletion, they can continue now.
+// cluster is the cluster which the HelmRequests live in, "" means the global cluster
+func (c *Controller) enqueueDependencies(hr *v1alpha1.HelmRequest, cluster string) {
+	keys, _ := indexDependencies(hr)
+	for _, key := range keys {
+		if cluster == "" {
+			c.workQueue.Add(key)
+		} else {
+			c.getClusterWorkQueue(cluster).Add(clusterKey(key, cluster))
+		}
+	}
+}
+
+// deletedHelmRequests are the HelmRequests removed from the informers, whose releases are not deleted yet.
+// The delete handlers store them here and enqueue their keys, so helm runs in the workers instead of the
+// handlers. Every replica stores them, an item is only removed after the release is deleted by this replica
+// or the HelmRequest is re-created, so the one who owns it later can still delete the release.
+type deletedHelmRequests struct {
+	lock  sync.Mutex
+	items map[string]*v1alpha1.HelmRequest
+}
+
+func (d *deletedHelmRequests) add(key string, hr *v1alpha1.HelmRequest) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.items == nil {
+		d.items = map[string]*v1alpha1.HelmRequest{}
+	}
+	d.items[key] = hr
+}
+
+func (d *deletedHelmRequests) get(key string) (*v1alpha1.HelmRequest, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	hr, ok := d.items[key]
+	return hr, ok
+}
+
+// keys returns the keys of all the items
+func (d *deletedHelmRequests) keys() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var keys []string
+	for key := range d.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (d *deletedHelmRequests) remove(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.items, key)
+}
+
+// syncDeletedHelmRequest deletes the release of a HelmRequest removed from the informer, the errors are
+// returned to be retried with backoff, since nothing else enqueues it again
+func (c *Controller) syncDeletedHelmRequest(key string, hr *v1alpha1.HelmRequest) error {
+	// kept until this replica owns it, it's enqueued again when the shards are changed
+	if !c.ownsHelmRequest(hr) {
+		return nil
+	}
+	if err := c.deleteHelmRequest(hr); err != nil {
+		if isShardMovedError(err) {
+			klog.Infof("%s, stop deleting it", err.Error())
+			return nil
+		}
+		c.sendFailedDeleteEvent(hr, err)
+		return err
+	}
+	c.deleted.remove(key)
+	c.getEventRecorder(hr).Event(hr, corev1.EventTypeNormal, SuccessfulDelete,
+		fmt.Sprintf("Deleted HelmRequest: %s", hr.GetName()))
+	return nil
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeletedHelmRequests(t *testing.T) {
+	var d deletedHelmRequests
+	_, ok := d.get("default/nginx")
+	assert.False(t, ok)
+
+	hr := &v1alpha1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"}}
+	d.add("default/nginx", hr)
+	d.add("business/default/nginx", hr.DeepCopy())
+	result, ok := d.get("default/nginx")
+	assert.True(t, ok)
+	assert.Equal(t, hr, result)
+
+	keys := d.keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"business/default/nginx", "default/nginx"}, keys)
+
+	d.remove("default/nginx")
+	_, ok = d.get("default/nginx")
+	assert.False(t, ok)
+	_, ok = d.get("business/default/nginx")
+	assert.True(t, ok)
+}
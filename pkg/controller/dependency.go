@@ -2,31 +2,66 @@ package controller
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/alauda/captain/pkg/helmrequest"
 	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	funk "github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 )
 
+const (
+	// DependencyNotFound means some of the dependencies not exist
+	DependencyNotFound = "DependencyNotFound"
+	// DependencyNotSynced means some of the dependencies are not synced to the target clusters yet
+	DependencyNotSynced = "DependencyNotSynced"
+	// DependencyCycle means the dependencies of the HelmRequest have a cycle
+	DependencyCycle = "DependencyCycle"
+	// DependenciesSynced means all the dependencies are synced
+	DependenciesSynced = "DependenciesSynced"
+)
+
+// dependencyError describes why the dependencies of a HelmRequest are not met
+type dependencyError struct {
+	reason   string
+	blocking []string
+}
+
+func (e *dependencyError) Error() string {
+	return fmt.Sprintf("dependencies not met: %s", strings.Join(e.blocking, "; "))
+}
+
 // getHelmRequestDependencies get dependencies for a HelmRequest resource
-// If the target HelmRequest has no dependencies, return nil. Otherwise get the dependencies and return
-func (c *Controller) getHelmRequestDependencies(hr *v1alpha1.HelmRequest) ([]*v1alpha1.HelmRequest, error) {
+// If the target HelmRequest has no dependencies, return nil. Otherwise get the dependencies and return.
+// Dependencies can be <name> or <namespace>/<name>, and they are looked up in the same cluster as the
+// HelmRequest. Dependencies not found are returned as a list of <namespace>/<name>.
+func (c *Controller) getHelmRequestDependencies(hr *v1alpha1.HelmRequest) ([]*v1alpha1.HelmRequest, []string, error) {
 	var data []*v1alpha1.HelmRequest
+	var missing []string
 	deps := hr.Spec.Dependencies
 	if len(deps) == 0 {
 		klog.V(4).Infof("HelmRequest %s has no dependencies", hr.GetName())
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	for _, name := range deps {
-		d, err := c.getHelmRequest(hr.GetNamespace(), name)
+	for _, dep := range deps {
+		namespace, name := helmrequest.ParseDependency(dep, hr.GetNamespace())
+		d, err := c.getHelmRequestLister(hr.ClusterName).HelmRequests(namespace).Get(name)
 		if err != nil {
-			klog.Errorf("Retrieve dependency %s for %s error: %s", name, hr.GetName(), err.Error())
-			return nil, err
+			if apierrors.IsNotFound(err) {
+				missing = append(missing, fmt.Sprintf("%s/%s", namespace, name))
+				continue
+			}
+			klog.Errorf("Retrieve dependency %s for %s error: %s", dep, hr.GetName(), err.Error())
+			return nil, nil, err
 		}
 		data = append(data, d)
 	}
 
-	return data, nil
+	return data, missing, nil
 
 }
 
@@ -34,43 +69,171 @@ func (c *Controller) getHelmRequestDependencies(hr *v1alpha1.HelmRequest) ([]*v1
 // satisfied. This is not and easy job, since we support installToAllClusters, and the clusters live and
 // go all the time. For more details please check: http://confluence.alaudatech.com/pages/viewpage.action?pageId=48729300
 // If the check not pass or somethings goes wrong, return an error contains the detailed reson, this is
-// better than a bool var.
-// TODO: fix when clusterName is ""
+// better than a bool var. The result is also recorded in the DependenciesMet condition.
 func (c *Controller) checkDependenciesForHelmRequest(hr *v1alpha1.HelmRequest) error {
-	deps, err := c.getHelmRequestDependencies(hr)
-	if err != nil {
+	if len(hr.Spec.Dependencies) == 0 {
+		return nil
+	}
+
+	err := c.findBlockingDependencies(hr)
+	condition := HelmRequestCondition{
+		Type:   DependenciesMet,
+		Status: corev1.ConditionTrue,
+		Reason: DependenciesSynced,
+	}
+	if e, ok := err.(*dependencyError); ok {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = e.reason
+		condition.Message = e.Error()
+	} else if err != nil {
 		return err
 	}
 
-	if !hr.Spec.InstallToAllClusters {
-		// the easy one....okay not so easy
-		cluster := hr.Spec.ClusterName
+	if err := c.setHelmRequestCondition(hr, condition); err != nil {
+		klog.Warningf("set dependencies condition for helmrequest %s error: %s", hr.GetName(), err.Error())
+	}
+	return err
+}
 
-		for _, dep := range deps {
-			if !dep.IsClusterSynced(cluster) && (cluster == "" && !dep.IsClusterSynced(c.clusterConfig.globalClusterName)) {
-				return fmt.Errorf("dependency %s of %s is not synced to cluster %s yet", dep.Name, hr.Name, cluster)
-			}
+// findBlockingDependencies returns a *dependencyError contains all the dependencies that block
+// the HelmRequest, and on which cluster.
+func (c *Controller) findBlockingDependencies(hr *v1alpha1.HelmRequest) error {
+	cycle, err := helmrequest.FindCycle(hr, func(namespace, name string) (*v1alpha1.HelmRequest, error) {
+		return c.getHelmRequestLister(hr.ClusterName).HelmRequests(namespace).Get(name)
+	})
+	if err != nil {
+		return err
+	}
+	if cycle != nil {
+		return &dependencyError{
+			reason:   DependencyCycle,
+			blocking: []string{fmt.Sprintf("dependency cycle %s", strings.Join(cycle, " -> "))},
 		}
-		return nil
 	}
 
-	clusters, err := c.getAllClusters()
+	deps, missing, err := c.getHelmRequestDependencies(hr)
 	if err != nil {
-		return fmt.Errorf("get clusters info error when check dependencies for %s : %s", hr.Name, err.Error())
+		return err
+	}
+	if len(missing) > 0 {
+		e := &dependencyError{reason: DependencyNotFound}
+		for _, item := range missing {
+			e.blocking = append(e.blocking, fmt.Sprintf("%s not found", item))
+		}
+		return e
+	}
+
+	var clusters []string
+	if !hr.Spec.InstallToAllClusters {
+		clusters = append(clusters, hr.Spec.ClusterName)
+	} else {
+		items, err := c.getAllClusters()
+		if err != nil {
+			return fmt.Errorf("get clusters info error when check dependencies for %s : %s", hr.Name, err.Error())
+		}
+		for _, item := range items {
+			clusters = append(clusters, item.Name)
+		}
 	}
 
-	for _, item := range clusters {
-		for _, dep := range deps {
-			if !dep.IsClusterSynced(item.Name) {
-				return fmt.Errorf("dependency %s of %s is not synced to cluster %s yet", dep.Name, hr.Name, item.Name)
+	e := &dependencyError{reason: DependencyNotSynced}
+	for _, dep := range deps {
+		var notSynced []string
+		for _, cluster := range clusters {
+			if dep.IsClusterSynced(cluster) {
+				continue
+			}
+			// "" means the global cluster
+			if cluster == "" && dep.IsClusterSynced(c.clusterConfig.globalClusterName) {
+				continue
 			}
+			if cluster == "" {
+				cluster = c.clusterConfig.globalClusterName
+			}
+			notSynced = append(notSynced, cluster)
+		}
+		if len(notSynced) > 0 {
+			e.blocking = append(e.blocking, fmt.Sprintf("%s/%s not synced to cluster %s",
+				dep.GetNamespace(), dep.GetName(), strings.Join(notSynced, ",")))
 		}
 	}
+	if len(e.blocking) > 0 {
+		return e
+	}
 	return nil
+}
 
+// dependentsIndex is the name of the informer index which maps a HelmRequest (<namespace>/<name>) to
+// the HelmRequests depend on it
+const dependentsIndex = "dependencies"
+
+// indexDependencies returns the dependencies of a HelmRequest as <namespace>/<name>
+func indexDependencies(obj interface{}) ([]string, error) {
+	hr, ok := obj.(*v1alpha1.HelmRequest)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, dep := range hr.Spec.Dependencies {
+		namespace, name := helmrequest.ParseDependency(dep, hr.GetNamespace())
+		keys = append(keys, fmt.Sprintf("%s/%s", namespace, name))
+	}
+	return keys, nil
 }
 
-// getHelmRequest get a HelmRequest by namespace and name
-func (c *Controller) getHelmRequest(namespace, name string) (*v1alpha1.HelmRequest, error) {
-	return c.helmRequestLister.HelmRequests(namespace).Get(name)
+// getHelmRequestIndexer get the indexer of HelmRequests for a cluster, "" means the global cluster
+func (c *Controller) getHelmRequestIndexer(name string) cache.Indexer {
+	if name == "" {
+		return c.helmRequestIndexer
+	}
+	return c.getClusterHelmRequestIndexers()[name]
+}
+
+// newlySyncedClusters returns the clusters a HelmRequest is synced to in the new version but not
+// in the old one.
+func newlySyncedClusters(old, new *v1alpha1.HelmRequest) []string {
+	if !new.Spec.InstallToAllClusters {
+		if new.Status.Phase == v1alpha1.HelmRequestSynced && old.Status.Phase != v1alpha1.HelmRequestSynced {
+			return []string{new.Spec.ClusterName}
+		}
+		return nil
+	}
+
+	var clusters []string
+	for _, item := range new.Status.SyncedClusters {
+		if !funk.ContainsString(old.Status.SyncedClusters, item) {
+			clusters = append(clusters, item)
+		}
+	}
+	return clusters
+}
+
+// enqueueDependents enqueue the HelmRequests depend on this one immediately when it is synced to a
+// new cluster, so they don't have to wait for the rate limited retry.
+// cluster is the cluster which the HelmRequests live in, "" means the global cluster
+func (c *Controller) enqueueDependents(old, new *v1alpha1.HelmRequest, cluster string) {
+	clusters := newlySyncedClusters(old, new)
+	if len(clusters) == 0 {
+		return
+	}
+
+	indexer := c.getHelmRequestIndexer(cluster)
+	if indexer == nil {
+		return
+	}
+	items, err := indexer.ByIndex(dependentsIndex, fmt.Sprintf("%s/%s", new.GetNamespace(), new.GetName()))
+	if err != nil {
+		klog.Errorf("get dependents of helmrequest %s error: %s", new.GetName(), err.Error())
+		return
+	}
+
+	for _, item := range items {
+		klog.Infof("helmrequest %s/%s synced to cluster %s, enqueue dependent %s", new.GetNamespace(), new.GetName(),
+			strings.Join(clusters, ","), item.(*v1alpha1.HelmRequest).GetName())
+		if cluster == "" {
+			c.enqueueHelmRequest(item)
+		} else {
+			c.enqueueClusterHelmRequest(item, cluster)
+		}
+	}
 }
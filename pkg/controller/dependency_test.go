@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIndexDependencies(t *testing.T) {
+	hr := &v1alpha1.HelmRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+		Spec:       v1alpha1.HelmRequestSpec{Dependencies: []string{"b", "infra/c"}},
+	}
+	keys, err := indexDependencies(hr)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"default/b", "infra/c"}, keys)
+}
+
+func TestNewlySyncedClusters(t *testing.T) {
+	old := &v1alpha1.HelmRequest{
+		Spec:   v1alpha1.HelmRequestSpec{ClusterName: "business"},
+		Status: v1alpha1.HelmRequestStatus{Phase: v1alpha1.HelmRequestPending},
+	}
+	new := old.DeepCopy()
+	new.Status.Phase = v1alpha1.HelmRequestSynced
+	assert.Equal(t, []string{"business"}, newlySyncedClusters(old, new))
+	assert.Nil(t, newlySyncedClusters(new, new))
+
+	old = &v1alpha1.HelmRequest{
+		Spec:   v1alpha1.HelmRequestSpec{InstallToAllClusters: true},
+		Status: v1alpha1.HelmRequestStatus{SyncedClusters: []string{"global"}},
+	}
+	new = old.DeepCopy()
+	new.Status.SyncedClusters = []string{"global", "business"}
+	assert.Equal(t, []string{"business"}, newlySyncedClusters(old, new))
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// DeprecatedAPIs means the chart uses deprecated APIs, or APIs not served by the target clusters
+	DeprecatedAPIs = "DeprecatedAPIs"
+
+	// DeprecatedAPI is the reason of the events for the deprecated APIs
+	DeprecatedAPI = "DeprecatedAPI"
+	// RemovedAPI is the reason of the events for the APIs removed in the target cluster
+	RemovedAPI = "RemovedAPI"
+)
+
+// recordAPIWarnings records an event for every API warning, and returns the messages prefixed with the
+// cluster name
+func (c *Controller) recordAPIWarnings(hr *v1alpha1.HelmRequest, info *cluster.Info, warnings []helm.APIWarning) []string {
+	var messages []string
+	for _, w := range warnings {
+		message := fmt.Sprintf("cluster %s: %s", info.GetName(), w.Message)
+		reason := DeprecatedAPI
+		if w.Removed {
+			reason = RemovedAPI
+		}
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, reason, message)
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// setDeprecatedAPIsCondition sets the DeprecatedAPIs condition with the warnings. If there is none, the
+// condition is cleared if it exists.
+func (c *Controller) setDeprecatedAPIsCondition(hr *v1alpha1.HelmRequest, warnings []string) {
+	condition := HelmRequestCondition{
+		Type:   DeprecatedAPIs,
+		Status: corev1.ConditionFalse,
+	}
+	if len(warnings) > 0 {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = DeprecatedAPI
+		condition.Message = strings.Join(warnings, "; ")
+	} else if !c.isHelmRequestConditionTrue(hr, DeprecatedAPIs) {
+		return
+	}
+
+	if err := c.setHelmRequestCondition(hr, condition); err != nil {
+		klog.Warningf("set condition %s of helmrequest %s error: %s", DeprecatedAPIs, hr.GetName(), err.Error())
+	}
+}
@@ -68,6 +68,8 @@ func (c *Controller) newHelmRequestHandler() cache.ResourceEventHandler {
 		// 3. old 1 / new N => spec and version changed
 		// 4. old N / new 1 => spec and version changed
 
+		c.enqueueDependents(oldHR, newHR, "")
+
 		if oldHR.Spec.InstallToAllClusters && newHR.Spec.InstallToAllClusters {
 			c.enqueueHelmRequest(new)
 		} else {
@@ -88,7 +90,8 @@ func (c *Controller) newHelmRequestHandler() cache.ResourceEventHandler {
 				klog.V(4).Infof("spec equal, not update: %s", newHR.Name)
 				return
 			}
-			klog.V(4).Infof("old hr: %+v, new hr: %+v", oldHR.Spec, newHR.Spec)
+			// the spec may contain sensitive values, never log it
+			klog.V(4).Infof("spec changed: %s/%s", newHR.Namespace, newHR.Name)
 			c.enqueueHelmRequest(new)
 		}
 	}
@@ -115,6 +118,8 @@ func (c *Controller) newClusterHelmRequestHandler(name string) cache.ResourceEve
 		// 3. old 1 / new N => spec and version changed
 		// 4. old N / new 1 => spec and version changed
 
+		c.enqueueDependents(oldHR, newHR, name)
+
 		if oldHR.Spec.InstallToAllClusters && newHR.Spec.InstallToAllClusters {
 			c.enqueueClusterHelmRequest(new, name)
 		} else {
@@ -135,13 +140,15 @@ func (c *Controller) newClusterHelmRequestHandler(name string) cache.ResourceEve
 				klog.V(4).Infof("spec equal, not update: %s", newHR.Name)
 				return
 			}
-			klog.V(4).Infof("old hr: %+v, new hr: %+v", oldHR.Spec, newHR.Spec)
+			// the spec may contain sensitive values, never log it
+			klog.V(4).Infof("spec changed: %s/%s", newHR.Namespace, newHR.Name)
 			c.enqueueClusterHelmRequest(new, name)
 		}
 	}
 
 	addFunc := func(obj interface{}) {
-		klog.Infof("receive hr create event: %+v", obj)
+		hr := obj.(*alpha1.HelmRequest)
+		klog.Infof("receive hr create event: %s/%s", hr.Namespace, hr.Name)
 		c.enqueueClusterHelmRequest(obj, name)
 	}
 
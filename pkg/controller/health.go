@@ -0,0 +1,302 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ClusterUnreachable means the target clusters of the HelmRequest cannot be accessed, the sync or
+	// delete will be retried when they are back
+	ClusterUnreachable = "ClusterUnreachable"
+
+	// probeInterval is the interval to probe the clusters
+	probeInterval = 30 * time.Second
+	// probeTimeout is the timeout of a single probe
+	probeTimeout = 10 * time.Second
+)
+
+var (
+	clusterReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "captain_cluster_reachable",
+		Help: "Whether the apiserver of the cluster can be accessed, 1 for reachable.",
+	}, []string{"cluster"})
+
+	clusterInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "captain_cluster_info",
+		Help: "The kubernetes version of the cluster, always 1.",
+	}, []string{"cluster", "version"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(clusterReachable, clusterInfo)
+}
+
+// clusterHealth is the result of the last probe of a cluster
+type clusterHealth struct {
+	Reachable bool
+	// Version is the git version of the apiserver, empty if unreachable
+	Version string
+	// Message is the error of the last probe
+	Message       string
+	LastProbeTime time.Time
+}
+
+// clusterHealths stores the health of all the clusters, by cluster name
+type clusterHealths struct {
+	lock  sync.RWMutex
+	items map[string]clusterHealth
+}
+
+func (h *clusterHealths) get(name string) (clusterHealth, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	item, ok := h.items[name]
+	return item, ok
+}
+
+// set stores the health of a cluster, returns true if the cluster is back
+func (h *clusterHealths) set(name string, health clusterHealth) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.items == nil {
+		h.items = map[string]clusterHealth{}
+	}
+	old, ok := h.items[name]
+	h.items[name] = health
+	return ok && !old.Reachable && health.Reachable
+}
+
+// prune removes the clusters not in names, and returns their last health
+func (h *clusterHealths) prune(names map[string]bool) map[string]clusterHealth {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	removed := map[string]clusterHealth{}
+	for name, health := range h.items {
+		if !names[name] {
+			removed[name] = health
+			delete(h.items, name)
+		}
+	}
+	return removed
+}
+
+// clusterUnreachableError means some target clusters are known to be unreachable by the prober
+type clusterUnreachableError struct {
+	clusters []string
+	messages []string
+}
+
+func (e *clusterUnreachableError) Error() string {
+	return fmt.Sprintf("cluster %s unreachable: %s", strings.Join(e.clusters, ","), strings.Join(e.messages, "; "))
+}
+
+func isClusterUnreachableError(err error) bool {
+	_, ok := err.(*clusterUnreachableError)
+	return ok
+}
+
+// checkClustersReachable returns a clusterUnreachableError if any of the clusters failed the last probe.
+// The clusters not probed yet are treated as reachable.
+func (c *Controller) checkClustersReachable(clusters ...*cluster.Info) error {
+	var e clusterUnreachableError
+	for _, info := range clusters {
+		if health, ok := c.clusterHealths.get(info.Name); ok && !health.Reachable {
+			e.clusters = append(e.clusters, info.GetName())
+			e.messages = append(e.messages, health.Message)
+		}
+	}
+	if len(e.clusters) == 0 {
+		return nil
+	}
+	return &e
+}
+
+// isUnreachableDeletionTimeout checks if the HelmRequest has been waiting for it's unreachable clusters to
+// be deleted for longer than the unreachable deletion timeout
+func (c *Controller) isUnreachableDeletionTimeout(hr *v1alpha1.HelmRequest) bool {
+	if c.unreachableDeletionTimeout <= 0 || hr.DeletionTimestamp == nil {
+		return false
+	}
+	return time.Since(hr.DeletionTimestamp.Time) > c.unreachableDeletionTimeout
+}
+
+// skipUnreachableClusters returns the clusters not in the unreachable error, the releases in the unreachable
+// ones are left as they are, a warning event is recorded for them
+func (c *Controller) skipUnreachableClusters(hr *v1alpha1.HelmRequest, clusters []*cluster.Info,
+	e *clusterUnreachableError) []*cluster.Info {
+	unreachable := map[string]bool{}
+	for _, name := range e.clusters {
+		unreachable[name] = true
+	}
+	var result []*cluster.Info
+	for _, info := range clusters {
+		if !unreachable[info.GetName()] {
+			result = append(result, info)
+		}
+	}
+	c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, ClusterUnreachable,
+		fmt.Sprintf("cluster %s unreachable for longer than %s, the release is left in it",
+			strings.Join(e.clusters, ","), c.unreachableDeletionTimeout))
+	return result
+}
+
+// runClusterProber probes all the clusters periodically
+func (c *Controller) runClusterProber(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		c.probeClusters()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeClusters probes the current cluster and all the Clusters. The HelmRequests targeting the
+// clusters which are back are re-queued.
+func (c *Controller) probeClusters() {
+	clusters, err := c.getAllClusters()
+	if err != nil {
+		klog.Errorf("list clusters for probe error: %s", err.Error())
+		return
+	}
+	if current, err := c.getClusterInfo(""); err == nil {
+		clusters = append(clusters, current)
+	}
+	c.pruneClusters(clusters)
+
+	var wg sync.WaitGroup
+	for _, info := range clusters {
+		wg.Add(1)
+		go func(info *cluster.Info) {
+			defer wg.Done()
+			health := probeCluster(info)
+			old, _ := c.clusterHealths.get(info.Name)
+			recordClusterHealth(info, old, health)
+			if c.clusterHealths.set(info.Name, health) {
+				klog.Infof("cluster %s is reachable again, re-sync it's helmrequests", info.GetName())
+				c.enqueueHelmRequestsForCluster(info)
+			}
+		}(info)
+	}
+	wg.Wait()
+}
+
+// pruneClusters forgets the clusters deleted since the last probe, including their client caches
+func (c *Controller) pruneClusters(clusters []*cluster.Info) {
+	names := map[string]bool{}
+	for _, info := range clusters {
+		names[info.Name] = true
+	}
+	for name, health := range c.clusterHealths.prune(names) {
+		klog.Infof("cluster %s is deleted, forget it", name)
+		forgetClusterHealth(name, health)
+		cluster.EvictClientCache(name)
+	}
+}
+
+// probeCluster gets the version of a cluster
+func probeCluster(info *cluster.Info) clusterHealth {
+	health := clusterHealth{LastProbeTime: time.Now()}
+
+	cfg := info.ToRestConfig()
+	cfg.Timeout = probeTimeout
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		health.Message = err.Error()
+		return health
+	}
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		klog.Warningf("probe cluster %s error: %s", info.GetName(), err.Error())
+		health.Message = err.Error()
+		return health
+	}
+	health.Reachable = true
+	health.Version = version.GitVersion
+	return health
+}
+
+// recordClusterHealth exposes the health of a cluster in metrics
+func recordClusterHealth(info *cluster.Info, old, health clusterHealth) {
+	name := info.GetName()
+	if old.Version != "" && old.Version != health.Version {
+		clusterInfo.DeleteLabelValues(name, old.Version)
+	}
+	if !health.Reachable {
+		clusterReachable.WithLabelValues(name).Set(0)
+		return
+	}
+	clusterReachable.WithLabelValues(name).Set(1)
+	clusterInfo.WithLabelValues(name, health.Version).Set(1)
+}
+
+// forgetClusterHealth deletes the metrics of a deleted cluster
+func forgetClusterHealth(name string, health clusterHealth) {
+	clusterReachable.DeleteLabelValues(name)
+	if health.Version != "" {
+		clusterInfo.DeleteLabelValues(name, health.Version)
+	}
+}
+
+// enqueueHelmRequestsForCluster enqueues the HelmRequests which are synced to the cluster, including the
+// ones installed to all clusters and the ones in that cluster
+func (c *Controller) enqueueHelmRequestsForCluster(info *cluster.Info) {
+	hrs, err := c.helmRequestLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list helmrequests error: %s", err.Error())
+		return
+	}
+	for _, hr := range hrs {
+		name := c.getDeployCluster(hr)
+		if hr.Spec.InstallToAllClusters || name == info.Name || (name == "" && info.Name == cluster.DefaultClusterName) {
+			c.enqueueHelmRequest(hr)
+		}
+	}
+
+	if lister, ok := c.getClusterHelmRequestListers()[info.Name]; ok {
+		hrs, err := lister.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("list helmrequests of cluster %s error: %s", info.Name, err.Error())
+			return
+		}
+		for _, hr := range hrs {
+			c.enqueueClusterHelmRequest(hr, info.Name)
+		}
+	}
+}
+
+// setClusterUnreachableCondition sets the ClusterUnreachable condition, and records an event. If err is
+// nil, the condition is cleared if it exists.
+func (c *Controller) setClusterUnreachableCondition(hr *v1alpha1.HelmRequest, err error) {
+	condition := HelmRequestCondition{
+		Type:   ClusterUnreachable,
+		Status: corev1.ConditionFalse,
+	}
+	if e, ok := err.(*clusterUnreachableError); ok {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = "ProbeFailed"
+		condition.Message = e.Error()
+		c.getEventRecorder(hr).Event(hr, corev1.EventTypeWarning, ClusterUnreachable, e.Error())
+	} else if !c.isHelmRequestConditionTrue(hr, ClusterUnreachable) {
+		return
+	}
+
+	if err := c.setHelmRequestCondition(hr, condition); err != nil {
+		klog.Warningf("set condition %s of helmrequest %s error: %s", ClusterUnreachable, hr.GetName(), err.Error())
+	}
+}
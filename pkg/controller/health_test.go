@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCheckClustersReachable(t *testing.T) {
+	c := &Controller{}
+	business := &cluster.Info{Name: "business"}
+	global := &cluster.Info{Name: cluster.DefaultClusterName, Alias: "global"}
+
+	// not probed yet
+	assert.Nil(t, c.checkClustersReachable(business, global))
+
+	assert.False(t, c.clusterHealths.set("business", clusterHealth{Message: "connection refused"}))
+	assert.False(t, c.clusterHealths.set(cluster.DefaultClusterName, clusterHealth{Reachable: true}))
+
+	err := c.checkClustersReachable(business, global)
+	assert.True(t, isClusterUnreachableError(err))
+	assert.Equal(t, "cluster business unreachable: connection refused", err.Error())
+	assert.Nil(t, c.checkClustersReachable(global))
+
+	// back again
+	assert.True(t, c.clusterHealths.set("business", clusterHealth{Reachable: true}))
+	assert.False(t, c.clusterHealths.set("business", clusterHealth{Reachable: true}))
+	assert.Nil(t, c.checkClustersReachable(business, global))
+}
+
+func TestPruneClusters(t *testing.T) {
+	c := &Controller{}
+	business := &cluster.Info{Name: "business"}
+	global := &cluster.Info{Name: cluster.DefaultClusterName}
+	for _, info := range []*cluster.Info{business, global} {
+		health := clusterHealth{Reachable: true, Version: "v1.16.0"}
+		c.clusterHealths.set(info.Name, health)
+		recordClusterHealth(info, clusterHealth{}, health)
+	}
+
+	c.pruneClusters([]*cluster.Info{global})
+	_, ok := c.clusterHealths.get("business")
+	assert.False(t, ok)
+	_, ok = c.clusterHealths.get(cluster.DefaultClusterName)
+	assert.True(t, ok)
+	assert.False(t, clusterReachable.DeleteLabelValues("business"))
+	assert.False(t, clusterInfo.DeleteLabelValues("business", "v1.16.0"))
+	assert.True(t, clusterReachable.DeleteLabelValues(cluster.DefaultClusterName))
+}
+
+func TestSkipUnreachableClusters(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder, unreachableDeletionTimeout: time.Hour}
+	hr := &v1alpha1.HelmRequest{}
+	assert.False(t, c.isUnreachableDeletionTimeout(hr))
+
+	hr.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+	assert.False(t, c.isUnreachableDeletionTimeout(hr))
+	hr.DeletionTimestamp = &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}
+	assert.True(t, c.isUnreachableDeletionTimeout(hr))
+
+	business := &cluster.Info{Name: "business"}
+	global := &cluster.Info{Name: "global"}
+	e := &clusterUnreachableError{clusters: []string{"business"}, messages: []string{"connection refused"}}
+	assert.Equal(t, []*cluster.Info{global}, c.skipUnreachableClusters(hr, []*cluster.Info{business, global}, e))
+	assert.Equal(t, 1, len(recorder.Events))
+
+	// wait forever
+	c.unreachableDeletionTimeout = 0
+	assert.False(t, c.isUnreachableDeletionTimeout(hr))
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/alauda/captain/pkg/cluster"
@@ -11,7 +12,6 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -27,6 +27,7 @@ import (
 // 2. <cluster>/<namespace>/<name>
 func (c *Controller) syncHandler(key string) error {
 	klog.Infof("Start sync helmrequest: %s", key)
+	queueKey := key
 	clusterName, key := splitClusterKey(key)
 
 	// Convert the namespace/name string into a distinct namespace and name
@@ -42,19 +43,43 @@ func (c *Controller) syncHandler(key string) error {
 		// The HelmRequest resource may no longer exist, in which case we stop
 		// processing.
 		if errors.IsNotFound(err) {
+			if hr, ok := c.deleted.get(queueKey); ok {
+				return c.syncDeletedHelmRequest(queueKey, hr)
+			}
 			runtime.HandleError(fmt.Errorf("helmRequest '%s' in work queue no longer exists", key))
 			return nil
 		}
 
 		return err
 	}
+	// re-created, the release belongs to the new one
+	c.deleted.remove(queueKey)
 
 	helmRequest.ClusterName = clusterName
+	// the conditions are read at most once per sync
+	defer c.conditions.forget(helmRequest.GetUID())
+
+	if !c.ownsHelmRequest(helmRequest) {
+		klog.V(4).Infof("HelmRequest %s is not in the shards of this replica, skip it", key)
+		return nil
+	}
 
 	if !helmRequest.DeletionTimestamp.IsZero() {
 		klog.Infof("HelmRequest has not nil DeletionTimestamp, starting to delete it: %s", helmRequest.Name)
 		if err := c.deleteHelmRequest(helmRequest); err != nil {
-			c.sendFailedDeleteEvent(helmRequest, err)
+			// the new owner will delete it
+			if isShardMovedError(err) {
+				klog.Infof("%s, stop deleting it", err.Error())
+				return nil
+			}
+			// will be retried when the clusters are back
+			if isClusterUnreachableError(err) {
+				c.setClusterUnreachableCondition(helmRequest, err)
+				return nil
+			}
+			if !isDependentsExistError(err) {
+				c.sendFailedDeleteEvent(helmRequest, err)
+			}
 			return err
 		}
 		return nil
@@ -69,9 +94,12 @@ func (c *Controller) syncHandler(key string) error {
 
 	klog.Infof("dependency check pass for HelmRequest %s", helmRequest.GetName())
 
+	// the data of valuesFrom sources may be changed without changing the spec
+	sourcesSynced, sourcesHash := c.isSourcesSynced(helmRequest)
+
 	if !helmRequest.Spec.InstallToAllClusters {
 
-		if helm.IsHelmRequestSynced(helmRequest) {
+		if helm.IsHelmRequestSynced(helmRequest) && sourcesSynced {
 			klog.Infof("HelmRequest %s synced", helmRequest.Name)
 			if helmRequest.Status.Phase != v1alpha1.HelmRequestSynced {
 				klog.Infof("helm request phase not synced, trying to set it")
@@ -81,14 +109,32 @@ func (c *Controller) syncHandler(key string) error {
 		}
 		klog.Infof("sync HelmRequest %s to cluster %s", key, helmRequest.Spec.ClusterName)
 		if err := c.syncToCluster(helmRequest); err != nil {
+			if isShardMovedError(err) {
+				klog.Infof("%s, stop syncing it", err.Error())
+				return nil
+			}
 			c.setSyncFailedStatus(helmRequest, err)
+			// will be retried when the cluster is back
+			if isClusterUnreachableError(err) {
+				c.setClusterUnreachableCondition(helmRequest, err)
+				return nil
+			}
 			return err
 		}
-	} else if err := c.syncToAllClusters(key, helmRequest); err != nil {
+		c.setClusterUnreachableCondition(helmRequest, nil)
+	} else if err := c.syncToAllClusters(key, helmRequest, sourcesSynced); err != nil {
+		if isShardMovedError(err) {
+			klog.Infof("%s, stop syncing it", err.Error())
+			return nil
+		}
 		c.setSyncFailedStatus(helmRequest, err)
 		return err
 	}
 
+	if err := c.updateSourcesHash(helmRequest, sourcesHash); err != nil {
+		klog.Warningf("update values sources hash for helmrequest %s error: %s", helmRequest.GetName(), err.Error())
+	}
+
 	c.getEventRecorder(helmRequest).Event(helmRequest, v1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 	return nil
 }
@@ -104,7 +150,13 @@ func (c *Controller) syncToCluster(helmRequest *v1alpha1.HelmRequest) error {
 
 	klog.Infof("get cluster %s  endpoint: %s", info.Name, info.Endpoint)
 
-	if err := c.sync(info, helmRequest); err != nil {
+	if err := c.checkClustersReachable(info); err != nil {
+		return err
+	}
+
+	result, err := c.sync(info, helmRequest)
+	c.setSyncResultConditions(helmRequest, result)
+	if err != nil {
 		return err
 	}
 
@@ -127,31 +179,39 @@ func (c *Controller) enqueueHelmRequest(obj interface{}) {
 	c.workQueue.Add(key)
 }
 
-// deleteHandler is delete handler for HelmRequest in global cluster
+// deleteHandler is delete handler for HelmRequest in global cluster, the release is deleted by the workers,
+// helm never runs in the handler
 func (c *Controller) deleteHandler(obj interface{}) {
-	var err error
-	var key string
-	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-		runtime.HandleError(err)
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	hr, ok := obj.(*v1alpha1.HelmRequest)
+	if !ok {
 		return
 	}
 
-	hr := obj.(*v1alpha1.HelmRequest)
+	c.enqueueDependencies(hr, "")
 
-	err = c.deleteHelmRequest(hr)
-	if err != nil {
-		c.sendFailedDeleteEvent(hr, err)
-		runtime.HandleError(err)
-		c.workQueue.AddRateLimited(key)
-	} else {
-		c.getEventRecorder(hr).Event(hr, v1.EventTypeNormal, SuccessfulDelete,
-			fmt.Sprintf("Deleted HelmRequest: %s", hr.GetName()))
-	}
+	// recorded by all the replicas, the shards may be moved before the release is deleted
+	key := fmt.Sprintf("%s/%s", hr.GetNamespace(), hr.GetName())
+	c.deleted.add(key, hr)
+	c.workQueue.Add(key)
 }
 
 // deleteHelmRequest delete the installed chart about this HelmRequest
 // if InstallToAllClusters=true, delete it from all clusters
+// It will wait until all the HelmRequests depend on this one are deleted.
+// If the deletion policy is Retain, the release is left as it is, only the finalizer is removed.
 func (c *Controller) deleteHelmRequest(hr *v1alpha1.HelmRequest) error {
+	if util.GetAnnotation(hr, util.DeletionPolicyKey) == util.DeletionPolicyRetain {
+		klog.Infof("deletion policy of helmrequest %s is %s, retain the release", hr.GetName(), util.DeletionPolicyRetain)
+		return c.removeFinalizer(hr)
+	}
+
+	if err := c.checkDependentsForDeletion(hr); err != nil {
+		return err
+	}
+
 	// get clusters
 	var clusters []*cluster.Info
 	if hr.Spec.InstallToAllClusters {
@@ -173,6 +233,14 @@ func (c *Controller) deleteHelmRequest(hr *v1alpha1.HelmRequest) error {
 		clusters = append(clusters, info)
 	}
 
+	// don't wait for the timeouts, retry when all of them are back
+	if err := c.checkClustersReachable(clusters...); err != nil {
+		if !c.isUnreachableDeletionTimeout(hr) {
+			return err
+		}
+		clusters = c.skipUnreachableClusters(hr, clusters, err.(*clusterUnreachableError))
+	}
+
 	var errs []error
 
 	// loop to delete in all clusters
@@ -180,9 +248,15 @@ func (c *Controller) deleteHelmRequest(hr *v1alpha1.HelmRequest) error {
 		ci := *info
 		ci.Namespace = hr.Spec.Namespace
 		klog.Infof("delete HelmRequest %s for cluster %s", hr.GetName(), ci.Name)
+		done := c.helmOperations.acquire()
+		if err := c.checkOwnsHelmRequest(hr); err != nil {
+			done()
+			return err
+		}
 		err := helm.Delete(hr, &ci)
+		done()
 		if err != nil {
-			errs = append(errs, err)
+			errs = append(errs, clusterError(info, err))
 		}
 	}
 
@@ -215,37 +289,40 @@ func (c *Controller) removeFinalizer(helmRequest *v1alpha1.HelmRequest) error {
 
 // updateHelmRequestPhase update a helmrequest status
 // If this helmrequest not exist already(delete by user, remove the release)
+// The status is merge patched instead of updated, so the fields not known by the HelmRequestStatus type
+// (.status.conditions) will not be dropped.
 func (c *Controller) updateHelmRequestPhase(helmRequest *v1alpha1.HelmRequest, phase v1alpha1.HelmRequestPhase) error {
-	request := helmRequest.DeepCopy()
-	request.Status.Phase = phase
+	status := helmRequest.Status
+	// null will remove the field, which is what UpdateStatus does for an empty value
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"phase":          phase,
+			"lastSpecHash":   emptyToNil(status.LastSpecHash),
+			"syncedClusters": status.SyncedClusters,
+			"notes":          emptyToNil(status.Notes),
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
 
 	client := c.getAppClient(helmRequest)
-
-	// If the CustomResourceSubresources feature gate is not enabled,
-	// we must use Update instead of UpdateStatus to update the Status block of the HelmRequest resource.
-	// UpdateStatus will not allow changes to the Spec of the resource,
-	// which is ideal for ensuring nothing other than resource status has been updated.
-	_, err := client.AppV1alpha1().HelmRequests(helmRequest.Namespace).UpdateStatus(request)
+	_, err = client.AppV1alpha1().HelmRequests(helmRequest.Namespace).Patch(helmRequest.Name, types.MergePatchType, data, "status")
 	if err != nil {
-		if apierrors.IsConflict(err) {
-			klog.Warning("update helm request status conflict, retry...")
-			origin, err := client.AppV1alpha1().HelmRequests(helmRequest.Namespace).Get(helmRequest.Name, metav1.GetOptions{})
-			if err != nil {
-				if apierrors.IsNotFound(err) {
-					klog.Warning("helmrequest not found when trying to update status, delete the release...", helmRequest.Name)
-					return c.deleteHelmRequest(helmRequest)
-				}
-				return err
-			}
-			klog.Warningf("origin status: %+v, current: %+v", origin.Status, request.Status)
-			origin.Status = *request.Status.DeepCopy()
-			_, err = client.AppV1alpha1().HelmRequests(helmRequest.Namespace).UpdateStatus(origin)
-			if err != nil {
-				klog.Error("retrying update helmrequest status error:", err)
-			}
-			return err
+		if apierrors.IsNotFound(err) {
+			klog.Warningf("helmrequest %s not found when trying to update status, delete the release...", helmRequest.Name)
+			return c.deleteHelmRequest(helmRequest)
 		}
 		klog.Errorf("update status for helmrequest %s error: %s", helmRequest.Name, err.Error())
 	}
 	return err
 }
+
+// emptyToNil returns nil for empty string, used to build merge patch
+func emptyToNil(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
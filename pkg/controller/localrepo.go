@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Jeffail/gabs/v2"
+	"github.com/alauda/captain/pkg/chartproxy"
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// SetChartCache set the chart cache, which is also the storage of local ChartRepos
+func (c *Controller) SetChartCache(cache *chartproxy.Cache) {
+	c.chartCache = cache
+	cache.SetLocalRepos(c)
+}
+
+// isLocalChartRepo check if the charts of a ChartRepo are stored by captain itself
+func isLocalChartRepo(cr *v1alpha1.ChartRepo) bool {
+	return util.GetAnnotation(cr, util.RepoTypeKey) == util.RepoTypeLocal
+}
+
+// IsLocalRepo implements chartproxy.LocalRepos
+func (c *Controller) IsLocalRepo(name string) bool {
+	cr, err := c.chartRepoLister.ChartRepos(c.chartRepoNamespace).Get(name)
+	return err == nil && isLocalChartRepo(cr)
+}
+
+// RefreshRepo implements chartproxy.LocalRepos, the ChartRepo will be synced as soon as possible. The chart may
+// be uploaded to a replica which doesn't handle the ChartRepo, so the refresh annotation is updated to notify
+// all the replicas by their informers.
+func (c *Controller) RefreshRepo(name string) {
+	c.chartRepoWorkQueue.Add(fmt.Sprintf("%s/%s", c.chartRepoNamespace, name))
+
+	data := gabs.New()
+	data.Set(time.Now().Format(time.RFC3339Nano), "metadata", "annotations", util.RefreshAtKey)
+	_, err := c.appClientSet.AppV1alpha1().ChartRepos(c.chartRepoNamespace).Patch(name, types.MergePatchType, data.Bytes())
+	if err != nil {
+		klog.Errorf("update refresh annotation of chartrepo %s error: %s", name, err.Error())
+	}
+}
+
+// addLocalChartRepo generate the index for a local ChartRepo from the charts in the cache, and add
+// it to helm
+func (c *Controller) addLocalChartRepo(cr *v1alpha1.ChartRepo) error {
+	if c.chartCache == nil {
+		return fmt.Errorf("local chartrepo %s requires chart cache to be enabled", cr.GetName())
+	}
+
+	index, err := c.chartCache.LocalIndex(cr.GetName(), cr.Spec.URL)
+	if err != nil {
+		return err
+	}
+	return helm.AddLocalRepository(cr.GetName(), cr.Spec.URL, index)
+}
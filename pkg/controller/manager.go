@@ -13,7 +13,7 @@ func init() {
 }
 
 // NeedLeaderElection simply check token file to determine it's this a in-cluster config and enable
-// leader-election
+// leader-election. If sharding is enabled, all the replicas work on their own shards
 func (c *Controller) NeedLeaderElection() bool {
-	return c.restConfig.BearerTokenFile != ""
+	return c.shards == nil && c.restConfig.BearerTokenFile != ""
 }
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/alauda/captain/pkg/config"
+	"github.com/alauda/captain/pkg/shard"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// ShardByCluster means the HelmRequests are sharded by the cluster they are deployed to
+	ShardByCluster = "cluster"
+	// ShardByNamespace means the HelmRequests are sharded by the namespace they live in
+	ShardByNamespace = "namespace"
+)
+
+// newShardManager creates the shard manager if sharding is enabled, the owned keys are rebalanced when
+// the replicas are changed
+func (c *Controller) newShardManager(client kubernetes.Interface, opt *config.Options) (*shard.Manager, error) {
+	if !opt.EnableSharding {
+		return nil, nil
+	}
+	if opt.ShardBy != ShardByCluster && opt.ShardBy != ShardByNamespace {
+		return nil, fmt.Errorf("invalid shard-by %s, should be %s or %s", opt.ShardBy, ShardByCluster, ShardByNamespace)
+	}
+
+	namespace := opt.ShardLeaseNamespace
+	if namespace == "" {
+		namespace = opt.ChartRepoNamespace
+	}
+	identity := opt.ShardIdentity
+	if identity == "" {
+		identity = os.Getenv("POD_NAME")
+	}
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = hostname
+	}
+	return shard.NewManager(client, namespace, identity, opt.ShardLeaseDuration, c.rebalance)
+}
+
+// ownsHelmRequest checks if the HelmRequest is handled by this replica, always true if sharding is disabled
+func (c *Controller) ownsHelmRequest(hr *v1alpha1.HelmRequest) bool {
+	if c.shards == nil {
+		return true
+	}
+	return c.shards.Owns(c.helmRequestShardKey(hr))
+}
+
+// shardMovedError means the HelmRequest was moved to another replica before helm runs
+type shardMovedError struct {
+	name string
+}
+
+func (e *shardMovedError) Error() string {
+	return fmt.Sprintf("HelmRequest %s is moved to another replica", e.name)
+}
+
+// isShardMovedError checks if the error is returned because the HelmRequest is moved to another replica
+func isShardMovedError(err error) bool {
+	_, ok := err.(*shardMovedError)
+	return ok
+}
+
+// checkOwnsHelmRequest returns a shardMovedError if the HelmRequest is not handled by this replica any more.
+// It's checked again right before helm runs, as the sync may take long and the shards may be changed.
+func (c *Controller) checkOwnsHelmRequest(hr *v1alpha1.HelmRequest) error {
+	if c.ownsHelmRequest(hr) {
+		return nil
+	}
+	return &shardMovedError{name: hr.GetName()}
+}
+
+// helmRequestShardKey returns the key to shard the HelmRequest by. The HelmRequests installed to all
+// clusters are sharded by the cluster they live in.
+func (c *Controller) helmRequestShardKey(hr *v1alpha1.HelmRequest) string {
+	cluster := hr.ClusterName
+	if !hr.Spec.InstallToAllClusters {
+		cluster = c.getDeployCluster(hr)
+	}
+	if cluster == "" {
+		cluster = c.clusterConfig.globalClusterName
+	}
+	if c.shardBy == ShardByNamespace {
+		return path.Join("namespace", cluster, hr.Namespace)
+	}
+	return path.Join("cluster", cluster)
+}
+
+// ownsChartRepo checks if the Charts and the status of the ChartRepo are updated by this replica, always
+// true if sharding is disabled
+func (c *Controller) ownsChartRepo(cr *v1alpha1.ChartRepo) bool {
+	if c.shards == nil {
+		return true
+	}
+	return c.shards.Owns(path.Join("chartrepo", cr.Namespace, cr.Name))
+}
+
+// rebalance enqueues all the HelmRequests and ChartRepos when the shards are changed, including the deleted
+// HelmRequests whose releases are not deleted yet. The ones not owned are skipped by the workers, the ones
+// taken over from a dead replica are synced.
+func (c *Controller) rebalance() {
+	klog.Infof("shards changed, enqueue all the HelmRequests and ChartRepos")
+	hrs, err := c.helmRequestLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list helmrequests error: %s", err.Error())
+	}
+	for _, hr := range hrs {
+		c.enqueueHelmRequest(hr)
+	}
+
+	for name, lister := range c.getClusterHelmRequestListers() {
+		hrs, err := lister.List(labels.Everything())
+		if err != nil {
+			klog.Errorf("list helmrequests in cluster %s error: %s", name, err.Error())
+			continue
+		}
+		for _, hr := range hrs {
+			c.enqueueClusterHelmRequest(hr, name)
+		}
+	}
+
+	// the deleted ones are not in the listers
+	for _, key := range c.deleted.keys() {
+		if cluster, _ := splitClusterKey(key); cluster == "" {
+			c.workQueue.Add(key)
+		} else if queue := c.getClusterWorkQueue(cluster); queue != nil {
+			queue.Add(key)
+		}
+	}
+
+	repos, err := c.chartRepoLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list chartrepos error: %s", err.Error())
+	}
+	for _, cr := range repos {
+		c.enqueueChartRepo(cr)
+	}
+}
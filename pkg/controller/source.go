@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// sourcesIndex is the name of the informer index which maps a ConfigMap or Secret (<kind>/<namespace>/<name>)
+// to the HelmRequests read values from it
+const sourcesIndex = "sources"
+
+// helmRequestIndexers are the indexers of all the HelmRequest informers
+var helmRequestIndexers = cache.Indexers{
+	dependentsIndex: indexDependencies,
+	sourcesIndex:    indexSources,
+}
+
+// indexSources returns the ConfigMaps and Secrets the values of a HelmRequest are read from
+func indexSources(obj interface{}) ([]string, error) {
+	hr, ok := obj.(*v1alpha1.HelmRequest)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, item := range helm.GetSourceObjects(hr) {
+		keys = append(keys, item.String())
+	}
+	return keys, nil
+}
+
+// newSourceHandler create an event handler for ConfigMaps or Secrets, the HelmRequests read values from
+// them will be enqueued when they are changed
+func (c *Controller) newSourceHandler(kind string) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueSourceUsers(kind, obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			c.enqueueSourceUsers(kind, new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			c.enqueueSourceUsers(kind, obj)
+		},
+	}
+}
+
+// enqueueSourceUsers enqueue all the HelmRequests read values from this object, in all the clusters.
+// The sync decision is made by the hash of the sources, so it's ok to enqueue more than needed.
+func (c *Controller) enqueueSourceUsers(kind string, obj interface{}) {
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	key := helm.SourceObject{Kind: kind, Namespace: o.GetNamespace(), Name: o.GetName()}.String()
+
+	indexers := map[string]cache.Indexer{"": c.helmRequestIndexer}
+	for name, indexer := range c.getClusterHelmRequestIndexers() {
+		indexers[name] = indexer
+	}
+
+	for cluster, indexer := range indexers {
+		items, err := indexer.ByIndex(sourcesIndex, key)
+		if err != nil {
+			klog.Errorf("get helmrequests use %s error: %s", key, err.Error())
+			continue
+		}
+		for _, item := range items {
+			klog.V(4).Infof("values source %s changed, enqueue helmrequest %s", key, item.(*v1alpha1.HelmRequest).GetName())
+			if cluster == "" {
+				c.enqueueHelmRequest(item)
+			} else {
+				c.enqueueClusterHelmRequest(item, cluster)
+			}
+		}
+	}
+}
+
+// getSourcesHash generates a hash of the data of all the ConfigMaps and Secrets the values of a HelmRequest
+// are read from. Returns "" if there are none.
+func (c *Controller) getSourcesHash(hr *v1alpha1.HelmRequest) (string, error) {
+	objects := helm.GetSourceObjects(hr)
+	if len(objects) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	for _, item := range objects {
+		data, err := c.getSourceData(item)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return "", err
+			}
+			data = nil
+		}
+		fmt.Fprintf(h, "%s\n", item.String())
+		if data == nil {
+			fmt.Fprint(h, "missing\n")
+			continue
+		}
+		var keys []string
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(h, "%s=%x\n", k, sha256.Sum256(data[k]))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// getSourceData get the data of a ConfigMap or Secret from the listers
+func (c *Controller) getSourceData(o helm.SourceObject) (map[string][]byte, error) {
+	data := map[string][]byte{}
+	switch o.Kind {
+	case helm.SourceKindConfigMap:
+		cm, err := c.configMapLister.ConfigMaps(o.Namespace).Get(o.Name)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+	case helm.SourceKindSecret:
+		secret, err := c.secretLister.Secrets(o.Namespace).Get(o.Name)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range secret.Data {
+			data[k] = v
+		}
+	}
+	return data, nil
+}
+
+// isSourcesSynced checks if the data of the valuesFrom sources is the same as the last time the HelmRequest
+// was synced. It also returns the current hash, which should be recorded after the sync.
+func (c *Controller) isSourcesSynced(hr *v1alpha1.HelmRequest) (bool, string) {
+	h, err := c.getSourcesHash(hr)
+	if err != nil {
+		// let the sync report the error
+		klog.Warningf("get values sources hash for helmrequest %s error: %s", hr.GetName(), err.Error())
+		return false, ""
+	}
+	return h == util.GetAnnotation(hr, util.SourcesHashKey), h
+}
+
+// updateSourcesHash records the hash of the valuesFrom sources used by the last sync
+func (c *Controller) updateSourcesHash(hr *v1alpha1.HelmRequest, h string) error {
+	if h == util.GetAnnotation(hr, util.SourcesHashKey) {
+		return nil
+	}
+	value := "null"
+	if h != "" {
+		value = fmt.Sprintf("%q", h)
+	}
+	data := fmt.Sprintf(`{"metadata":{"annotations":{%q:%s}}}`, util.SourcesHashKey, value)
+	_, err := c.getAppClient(hr).AppV1alpha1().HelmRequests(hr.GetNamespace()).Patch(hr.GetName(), types.MergePatchType, []byte(data))
+	return err
+}
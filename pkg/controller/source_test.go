@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetSourcesHash(t *testing.T) {
+	cms := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	c := &Controller{
+		configMapLister: corelisters.NewConfigMapLister(cms),
+		secretLister:    corelisters.NewSecretLister(secrets),
+	}
+
+	hr := &v1alpha1.HelmRequest{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+	h, err := c.getSourcesHash(hr)
+	assert.Nil(t, err)
+	assert.Equal(t, "", h)
+
+	hr.Spec.ValuesFrom = []v1alpha1.ValuesFromSource{
+		{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "cm"}}},
+		{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}}},
+	}
+	missing, err := c.getSourcesHash(hr)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", missing)
+
+	cms.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cm"},
+		Data:       map[string]string{"values.yaml": "a: b"},
+	})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"},
+		Data:       map[string][]byte{"values.yaml": []byte("password: 1")},
+	}
+	secrets.Add(secret)
+	h1, err := c.getSourcesHash(hr)
+	assert.Nil(t, err)
+	assert.NotEqual(t, missing, h1)
+
+	secret = secret.DeepCopy()
+	secret.Data["values.yaml"] = []byte("password: 2")
+	secrets.Update(secret)
+	h2, err := c.getSourcesHash(hr)
+	assert.Nil(t, err)
+	assert.NotEqual(t, h1, h2)
+
+	keys, err := indexSources(hr)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ConfigMap/default/cm", "Secret/default/secret"}, keys)
+}
@@ -2,21 +2,20 @@ package controller
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/alauda/captain/pkg/cluster"
 	"github.com/alauda/captain/pkg/helm"
 	"github.com/alauda/captain/pkg/release"
 	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
 	funk "github.com/thoas/go-funk"
-	"helm.sh/helm/pkg/action"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog"
 )
 
 // syncToAllClusters install/upgrade release in all the clusters
-func (c *Controller) syncToAllClusters(key string, helmRequest *v1alpha1.HelmRequest) error {
+// If sourcesSynced is false, the data of valuesFrom sources is changed, it will be synced to all the clusters again.
+func (c *Controller) syncToAllClusters(key string, helmRequest *v1alpha1.HelmRequest, sourcesSynced bool) error {
 	clusters, err := c.getAllClusters()
 	if err != nil {
 		return err
@@ -24,7 +23,7 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *v1alpha1.HelmReq
 
 	var synced []string
 	var errs []error
-	equal := helm.IsHelmRequestSynced(helmRequest)
+	equal := helm.IsHelmRequestSynced(helmRequest) && sourcesSynced
 
 	// if not equal, we need to update helm status first
 	if !equal {
@@ -38,12 +37,30 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *v1alpha1.HelmReq
 	}
 	klog.Infof("origin synced clusters: %+v", synced)
 
+	unreachable := &clusterUnreachableError{}
+	var result syncResult
+	// the problems of the clusters skipped are unknown, so don't clear the conditions
+	skipped := false
 	for _, cr := range clusters {
 		if equal && funk.Contains(synced, cr.Name) {
+			skipped = true
+			continue
+		}
+		// skip the unreachable clusters, they will be synced when they are back
+		if err := c.checkClustersReachable(cr); err != nil {
+			errs = append(errs, err)
+			e := err.(*clusterUnreachableError)
+			unreachable.clusters = append(unreachable.clusters, e.clusters...)
+			unreachable.messages = append(unreachable.messages, e.messages...)
 			continue
 		}
 		klog.Infof("sync %s to cluster %s ....", key, cr.Name)
-		if err = c.sync(cr, helmRequest); err != nil {
+		r, err := c.sync(cr, helmRequest)
+		result.add(r)
+		if isShardMovedError(err) {
+			return err
+		}
+		if err != nil {
 			errs = append(errs, err)
 			klog.Infof("skip sync %s to %s, err is : %s, continue...", key, cr.Name, err.Error())
 			continue
@@ -58,6 +75,17 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *v1alpha1.HelmReq
 	klog.Infof("synced %s to clusters: %+v", key, synced)
 
 	err = errors.NewAggregate(errs)
+	if len(result.warnings) > 0 || !skipped {
+		c.setDeprecatedAPIsCondition(helmRequest, result.warnings)
+	}
+	if len(result.conflicts) > 0 || !skipped {
+		c.setConflictsCondition(helmRequest, result.conflicts)
+	}
+	if len(unreachable.clusters) > 0 {
+		c.setClusterUnreachableCondition(helmRequest, unreachable)
+	} else {
+		c.setClusterUnreachableCondition(helmRequest, nil)
+	}
 
 	if len(synced) >= len(clusters) {
 		// all synced
@@ -70,26 +98,45 @@ func (c *Controller) syncToAllClusters(key string, helmRequest *v1alpha1.HelmReq
 	return err
 }
 
-// sync install/update chart to one cluster
-func (c *Controller) sync(info *cluster.Info, helmRequest *v1alpha1.HelmRequest) error {
+// sync install/update chart to one cluster. The API warnings and the conflicts of the chart are recorded
+// as events and returned, prefixed with the cluster name.
+func (c *Controller) sync(info *cluster.Info, helmRequest *v1alpha1.HelmRequest) (syncResult, error) {
+	var result syncResult
 	ci := *info
 	ci.Namespace = helmRequest.Spec.Namespace
+	if err := ci.CheckCredentials(); err != nil {
+		return result, err
+	}
 	if err := release.EnsureCRDCreated(info.ToRestConfig()); err != nil {
 		klog.Errorf("sync release crd error: %s", err.Error())
-		return err
+		return result, clusterError(info, err)
+	}
+
+	keyring, err := c.getChartKeyring(helmRequest)
+	if err != nil {
+		return result, err
 	}
 
 	inCluster, _ := c.getClusterInfo("")
-	klog.V(2).Info("get current cluster info for valuesFrom: ", *inCluster)
-	rel, err := helm.Sync(helmRequest, &ci, inCluster)
+	klog.V(2).Infof("get current cluster info for valuesFrom: %s", inCluster.Endpoint)
+	done := c.helmOperations.acquire()
+	if err := c.checkOwnsHelmRequest(helmRequest); err != nil {
+		done()
+		return result, err
+	}
+	rel, err := helm.Sync(helmRequest, &ci, inCluster, keyring, func(items []helm.APIWarning) {
+		result.warnings = c.recordAPIWarnings(helmRequest, info, items)
+	}, func(conflicts []string) {
+		result.conflicts = c.recordConflicts(helmRequest, info, conflicts)
+	})
+	done()
 	if err != nil {
-		return err
+		return result, clusterError(info, err)
 	}
 
 	// record chart version for un-specified ones
 	msg := fmt.Sprintf("Choose chart version: %s %s", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
 	c.getEventRecorder(helmRequest).Event(helmRequest, corev1.EventTypeNormal, SuccessSynced, msg)
 
-	action.PrintRelease(os.Stdout, rel)
-	return nil
+	return result, nil
 }
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// getChartKeyring returns the local keyring path used to verify the chart of a HelmRequest.
+// Verification can be enabled on the HelmRequest or on the ChartRepo of it's chart. The keyring
+// secret set on ChartRepo always takes precedence, so the author of a HelmRequest can't replace the
+// trust root of a repo, the one on HelmRequest is only used if the ChartRepo has none. If verification
+// is not required, return "".
+func (c *Controller) getChartKeyring(hr *v1alpha1.HelmRequest) (string, error) {
+	var objs []metav1.Object
+
+	repoName, _ := util.ParseChartName(hr.Spec.Chart)
+	if repoName != "" {
+		repo, err := c.chartRepoLister.ChartRepos(c.chartRepoNamespace).Get(repoName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return "", err
+		}
+		if err == nil {
+			objs = append(objs, repo)
+		}
+	}
+	objs = append(objs, hr)
+
+	verify := false
+	secretName := ""
+	for _, obj := range objs {
+		verify = verify || util.IsAnnotationTrue(obj, util.VerifyKey)
+		if secretName == "" {
+			secretName = util.GetAnnotation(obj, util.KeyringSecretKey)
+		}
+	}
+
+	if !verify {
+		return "", nil
+	}
+
+	if secretName == "" {
+		return "", fmt.Errorf("chart verification is required for %s, but no keyring secret is set", hr.Spec.Chart)
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets(c.chartRepoNamespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get keyring secret %s/%s error: %s", c.chartRepoNamespace, secretName, err.Error())
+	}
+
+	data, ok := secret.Data[util.KeyringDataKey]
+	if !ok {
+		return "", fmt.Errorf("key %s missing in keyring secret %s", util.KeyringDataKey, secretName)
+	}
+
+	klog.V(4).Infof("verify chart %s with keyring from secret %s", hr.Spec.Chart, secretName)
+	return helm.SaveKeyring(secretName, data)
+}
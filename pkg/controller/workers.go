@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alauda/captain/pkg/config"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// workerOptions controls the concurrency of the workers and the retry backoff of the work queues
+type workerOptions struct {
+	helmRequestWorkers int
+	chartRepoWorkers   int
+	// clusterWorkers is the number of workers for each cluster other than the global one
+	clusterWorkers int
+
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	qps       float64
+	burst     int
+}
+
+// newWorkerOptions validates and returns the worker options from the command line options
+func newWorkerOptions(opt *config.Options) (workerOptions, error) {
+	w := workerOptions{
+		helmRequestWorkers: opt.HelmRequestWorkers,
+		chartRepoWorkers:   opt.ChartRepoWorkers,
+		clusterWorkers:     opt.ClusterWorkers,
+		baseDelay:          opt.RateLimiterBaseDelay,
+		maxDelay:           opt.RateLimiterMaxDelay,
+		qps:                opt.RateLimiterQPS,
+		burst:              opt.RateLimiterBurst,
+	}
+	if w.helmRequestWorkers < 1 || w.chartRepoWorkers < 1 || w.clusterWorkers < 1 {
+		return w, fmt.Errorf("the number of workers should be at least 1")
+	}
+	if w.baseDelay <= 0 || w.maxDelay < w.baseDelay {
+		return w, fmt.Errorf("invalid rate limiter delays, base: %s, max: %s", w.baseDelay, w.maxDelay)
+	}
+	if w.qps <= 0 || w.burst < 1 {
+		return w, fmt.Errorf("invalid rate limiter qps %v or burst %d", w.qps, w.burst)
+	}
+	return w, nil
+}
+
+// newRateLimiter creates a rate limiter like workqueue.DefaultControllerRateLimiter, the per item exponential
+// backoff and the overall limit are configurable
+func (w workerOptions) newRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(w.baseDelay, w.maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(w.qps), w.burst)},
+	)
+}
+
+// helmOperations limits the number of concurrent helm operations across all the workers, nil means no limit
+type helmOperations chan struct{}
+
+func newHelmOperations(max int) helmOperations {
+	if max <= 0 {
+		return nil
+	}
+	return make(helmOperations, max)
+}
+
+// acquire blocks until a helm operation is allowed, the returned function must be called when it's done
+func (h helmOperations) acquire() func() {
+	if h == nil {
+		return func() {}
+	}
+	h <- struct{}{}
+	return func() {
+		<-h
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alauda/captain/pkg/config"
+	"github.com/gsamokovarov/assert"
+)
+
+func TestNewWorkerOptions(t *testing.T) {
+	opt := &config.Options{
+		HelmRequestWorkers:   8,
+		ChartRepoWorkers:     2,
+		ClusterWorkers:       4,
+		RateLimiterBaseDelay: 100 * time.Millisecond,
+		RateLimiterMaxDelay:  time.Minute,
+		RateLimiterQPS:       10,
+		RateLimiterBurst:     100,
+	}
+	w, err := newWorkerOptions(opt)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, w.clusterWorkers)
+
+	limiter := w.newRateLimiter()
+	assert.Equal(t, 100*time.Millisecond, limiter.When("item"))
+	assert.Equal(t, 200*time.Millisecond, limiter.When("item"))
+
+	opt.ClusterWorkers = 0
+	_, err = newWorkerOptions(opt)
+	assert.NotNil(t, err)
+
+	opt.ClusterWorkers = 4
+	opt.RateLimiterMaxDelay = time.Millisecond
+	_, err = newWorkerOptions(opt)
+	assert.NotNil(t, err)
+}
+
+func TestHelmOperations(t *testing.T) {
+	// no limit
+	newHelmOperations(0).acquire()()
+
+	ops := newHelmOperations(1)
+	done := ops.acquire()
+	select {
+	case ops <- struct{}{}:
+		t.Fatal("acquired more than the limit")
+	default:
+	}
+	done()
+	ops.acquire()()
+}
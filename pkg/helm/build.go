@@ -0,0 +1,67 @@
+package helm
+
+import (
+	"bytes"
+	"path"
+
+	newkube "github.com/alauda/captain/pkg/kube"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/pkg/errors"
+	"helm.sh/helm/pkg/action"
+	"helm.sh/helm/pkg/chart"
+)
+
+// ConflictHandler handles the fields changed by both the chart and others, in the form of <Kind>/<name>: <field>
+type ConflictHandler func(conflicts []string)
+
+// setClientOptions sets the options of the kube client by the annotations of the HelmRequest, the CRDs
+// declared in the chart are collected from the rendered manifests
+func setClientOptions(options *newkube.Options, hr *v1alpha1.HelmRequest, heads []manifestHead) {
+	options.BuildPolicy = newkube.BuildStrict
+	if util.GetAnnotation(hr, util.BuildPolicyKey) == util.BuildPolicySkipPendingCRDs {
+		options.BuildPolicy = newkube.BuildSkipPendingCRDs
+	}
+	options.ConflictPolicy = newkube.ConflictOverwrite
+	if util.GetAnnotation(hr, util.ConflictPolicyKey) == util.ConflictPolicyFail {
+		options.ConflictPolicy = newkube.ConflictFail
+	}
+	options.CRDPolicy = getCRDPolicy(hr)
+
+	options.CRDKinds = map[string]bool{}
+	options.CRDs = nil
+	// the CRDs are installed by others, don't wait for them
+	if options.CRDPolicy == newkube.CRDSkip {
+		return
+	}
+	for _, h := range heads {
+		if h.Kind != "CustomResourceDefinition" {
+			continue
+		}
+		options.CRDKinds[path.Join(h.Spec.Group, h.Spec.Names.Kind)] = true
+		options.CRDs = append(options.CRDs, h.Metadata.Name)
+	}
+}
+
+// getCRDPolicy returns the CRD policy of the HelmRequest, empty if not set
+func getCRDPolicy(hr *v1alpha1.HelmRequest) newkube.CRDPolicy {
+	return newkube.CRDPolicy(util.GetAnnotation(hr, util.CRDPolicyKey))
+}
+
+// applyChartCRDs applies the CRDs in the crds/ directory of the chart and it's dependencies on upgrade, helm
+// only creates them on install. They are handled by the CRD policy of the kube client.
+func applyChartCRDs(cfg *action.Configuration, ch *chart.Chart) error {
+	for _, f := range ch.CRDs() {
+		res, err := cfg.KubeClient.Build(bytes.NewBuffer(f.Data))
+		if err != nil {
+			return errors.Wrapf(err, "failed to build CRD %s", f.Name)
+		}
+		if len(res) == 0 {
+			continue
+		}
+		if _, err := cfg.KubeClient.Create(res); err != nil {
+			return errors.Wrapf(err, "failed to apply CRD %s", f.Name)
+		}
+	}
+	return nil
+}
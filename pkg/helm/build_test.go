@@ -0,0 +1,36 @@
+package helm
+
+import (
+	"testing"
+
+	newkube "github.com/alauda/captain/pkg/kube"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetClientOptions(t *testing.T) {
+	hr := &v1alpha1.HelmRequest{}
+	heads := []manifestHead{{APIVersion: "stable.example.com/v1", Kind: "CronTab"}}
+	heads = append(heads, manifestHead{APIVersion: "apiextensions.k8s.io/v1beta1", Kind: "CustomResourceDefinition"})
+	heads[1].Metadata.Name = "crontabs.stable.example.com"
+	heads[1].Spec.Group = "stable.example.com"
+	heads[1].Spec.Names.Kind = "CronTab"
+
+	options := &newkube.Options{}
+	setClientOptions(options, hr, heads)
+	assert.Equal(t, newkube.BuildStrict, options.BuildPolicy)
+	assert.Equal(t, newkube.ConflictOverwrite, options.ConflictPolicy)
+	assert.Equal(t, map[string]bool{"stable.example.com/CronTab": true}, options.CRDKinds)
+	assert.Equal(t, []string{"crontabs.stable.example.com"}, options.CRDs)
+
+	hr.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{util.BuildPolicyKey: util.BuildPolicySkipPendingCRDs}}
+	setClientOptions(options, hr, heads)
+	assert.Equal(t, newkube.BuildSkipPendingCRDs, options.BuildPolicy)
+
+	hr.Annotations[util.CRDPolicyKey] = util.CRDPolicySkip
+	setClientOptions(options, hr, heads)
+	assert.Equal(t, newkube.CRDSkip, options.CRDPolicy)
+	assert.Equal(t, 0, len(options.CRDs))
+}
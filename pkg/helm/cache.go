@@ -0,0 +1,83 @@
+package helm
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/alauda/captain/pkg/util"
+	"helm.sh/helm/pkg/action"
+	"helm.sh/helm/pkg/cli"
+	"k8s.io/klog"
+)
+
+// ChartCache is a local cache for chart archives
+type ChartCache interface {
+	// Locate returns the local path of the chart archive, if prov is true, the provenance file should
+	// also be available next to the archive
+	Locate(repo, chart, version string, prov bool) (string, error)
+	// Cached returns the local path of the chart archive only if it's already cached, nothing should be
+	// downloaded. If the chart is not cached, returns os.ErrNotExist
+	Cached(repo, chart, version string) (string, error)
+}
+
+// chartCache is used to locate charts if set, otherwise charts are downloaded every time
+var chartCache ChartCache
+
+// SetChartCache set the chart cache used by all the actions
+func SetChartCache(c ChartCache) {
+	chartCache = c
+}
+
+// locateChart is a wrapper of LocateChart, it will try the chart cache first.
+func locateChart(opts *action.ChartPathOptions, name string, settings *cli.EnvSettings) (string, error) {
+	repo, chart := util.ParseChartName(name)
+	if chartCache != nil && repo != "" {
+		path, err := chartCache.Locate(repo, chart, opts.Version, opts.Verify)
+		if err == nil {
+			// a local path, only the verification will be done
+			return opts.LocateChart(path, settings)
+		}
+		klog.Warningf("locate chart %s from cache error: %s, download it directly", name, err.Error())
+	}
+	return opts.LocateChart(name, settings)
+}
+
+// cachedChart returns the local path of a chart already downloaded to the chart cache, or to the helm
+// repository cache by previous syncs. Nothing is downloaded, if the chart is not cached, returns
+// os.ErrNotExist. Like install, an empty version means the latest stable one, or the latest pre-release
+// if there is no stable one.
+func cachedChart(name, version string) (string, error) {
+	p, err := cachedChartVersion(name, version)
+	if err != nil && version == "" && !os.IsNotExist(err) {
+		return cachedChartVersion(name, ">0.0.0-0")
+	}
+	return p, err
+}
+
+func cachedChartVersion(name, version string) (string, error) {
+	repoName, chartName := util.ParseChartName(name)
+	if repoName == "" {
+		return "", os.ErrNotExist
+	}
+	if chartCache != nil {
+		return chartCache.Cached(repoName, chartName, version)
+	}
+
+	index, err := GetChartsForRepo(repoName)
+	if err != nil {
+		return "", err
+	}
+	cv, err := index.Get(chartName, version)
+	if err != nil {
+		return "", err
+	}
+	if len(cv.URLs) == 0 {
+		return "", os.ErrNotExist
+	}
+	p := filepath.Join(cli.New().RepositoryCache, path.Base(cv.URLs[0]))
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
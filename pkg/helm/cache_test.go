@@ -0,0 +1,45 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/helmpath"
+	"helm.sh/helm/pkg/helmpath/xdg"
+	"helm.sh/helm/pkg/repo"
+)
+
+func TestCachedChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captain-helm-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer os.Setenv(xdg.CacheHomeEnvVar, os.Getenv(xdg.CacheHomeEnvVar))
+	os.Setenv(xdg.CacheHomeEnvVar, dir)
+
+	index := repo.NewIndexFile()
+	index.Add(&chart.Metadata{Name: "nginx", Version: "1.0.0"}, "nginx-1.0.0.tgz", "https://example.com/charts", "sha")
+	index.Add(&chart.Metadata{Name: "redis", Version: "2.0.0-rc1"}, "redis-2.0.0-rc1.tgz", "https://example.com/charts", "sha")
+	assert.Nil(t, os.MkdirAll(helmpath.CachePath("repository"), 0755))
+	assert.Nil(t, index.WriteFile(helmpath.CachePath("repository", "stable-index.yaml"), 0644))
+
+	// never downloaded
+	_, err = cachedChart("stable/nginx", "")
+	assert.True(t, os.IsNotExist(err))
+
+	for _, name := range []string{"nginx-1.0.0.tgz", "redis-2.0.0-rc1.tgz"} {
+		assert.Nil(t, ioutil.WriteFile(helmpath.CachePath("repository", name), []byte("chart"), 0644))
+	}
+	p, err := cachedChart("stable/nginx", "")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "helm", "repository", "nginx-1.0.0.tgz"), p)
+	p, err = cachedChart("stable/redis", "")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "helm", "repository", "redis-2.0.0-rc1.tgz"), p)
+
+	_, err = cachedChart("stable/nginx", "2.0.0")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,104 @@
+package helm
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/Masterminds/semver"
+	"github.com/alauda/captain/pkg/cluster"
+	"helm.sh/helm/pkg/action"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chartutil"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog"
+)
+
+// getCapabilities discovers the kubernetes version and API versions of the target cluster. Unlike helm,
+// which falls back to the default API versions if any API group failed, the API versions of the groups
+// discovered are still used, so the charts checking .Capabilities.APIVersions get the right answer.
+func getCapabilities(getter action.RESTClientGetter, info *cluster.Info) (*chartutil.Capabilities, error) {
+	dc, err := getter.ToDiscoveryClient()
+	if err != nil {
+		return nil, fmt.Errorf("get discovery client for cluster %s error: %s", info.GetName(), err.Error())
+	}
+	version, err := dc.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("get kubernetes version of cluster %s error: %s", info.GetName(), err.Error())
+	}
+
+	groups, resources, err := dc.ServerGroupsAndResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("get api versions of cluster %s error: %s", info.GetName(), err.Error())
+		}
+		klog.Warningf("some api groups of cluster %s are not available: %s", info.GetName(), err.Error())
+	}
+
+	set := map[string]bool{}
+	for _, g := range groups {
+		for _, gv := range g.Versions {
+			set[gv.GroupVersion] = true
+		}
+	}
+	for _, r := range resources {
+		for _, item := range r.APIResources {
+			set[path.Join(r.GroupVersion, item.Kind)] = true
+		}
+	}
+	var versions []string
+	for k := range set {
+		versions = append(versions, k)
+	}
+	sort.Strings(versions)
+
+	return &chartutil.Capabilities{
+		APIVersions: chartutil.VersionSet(versions),
+		KubeVersion: chartutil.KubeVersion{
+			Version: version.GitVersion,
+			Major:   version.Major,
+			Minor:   version.Minor,
+		},
+	}, nil
+}
+
+// checkKubeVersion checks the kubeVersion constraints of the chart and all its sub charts, nested ones
+// included. The pre-release part of the cluster version is ignored, many vendors use it to mark their
+// builds, like v1.14.8-gke.12.
+func checkKubeVersion(ch *chart.Chart, caps *chartutil.Capabilities, info *cluster.Info) error {
+	version, err := semver.NewVersion(caps.KubeVersion.Version)
+	if err != nil {
+		klog.Warningf("invalid kubernetes version %s of cluster %s, skip the check", caps.KubeVersion.Version, info.GetName())
+		return nil
+	}
+	release := fmt.Sprintf("%d.%d.%d", version.Major(), version.Minor(), version.Patch())
+	return checkChartKubeVersion(ch, release, caps, info)
+}
+
+// checkChartKubeVersion checks the kubeVersion constraint of the chart, then walks its dependencies
+func checkChartKubeVersion(ch *chart.Chart, release string, caps *chartutil.Capabilities, info *cluster.Info) error {
+	if ch.Metadata != nil && ch.Metadata.KubeVersion != "" && !chartutil.IsCompatibleRange(ch.Metadata.KubeVersion, release) {
+		return fmt.Errorf("chart %s requires kubernetes version %s, but cluster %s is running %s",
+			ch.Name(), ch.Metadata.KubeVersion, info.GetName(), caps.KubeVersion.Version)
+	}
+	for _, sub := range ch.Dependencies() {
+		if err := checkChartKubeVersion(sub, release, caps, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCapabilities discovers the capabilities of the target cluster for the actions, and checks the chart
+// can be installed to it
+func setCapabilities(cfg *action.Configuration, ch *chart.Chart, info *cluster.Info) error {
+	caps, err := getCapabilities(cfg.RESTClientGetter, info)
+	if err != nil {
+		return err
+	}
+	if err := checkKubeVersion(ch, caps, info); err != nil {
+		return err
+	}
+	cfg.Capabilities = caps
+	return nil
+}
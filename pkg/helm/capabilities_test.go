@@ -0,0 +1,81 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type fakeGetter struct {
+	discovery discovery.CachedDiscoveryInterface
+}
+
+func (g *fakeGetter) ToRESTConfig() (*rest.Config, error) {
+	return &rest.Config{}, nil
+}
+
+func (g *fakeGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	return g.discovery, nil
+}
+
+func (g *fakeGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	return nil, nil
+}
+
+func TestGetCapabilities(t *testing.T) {
+	dc := &fakediscovery.FakeDiscovery{
+		Fake: &k8stesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "apps/v1",
+					APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment"}},
+				},
+				{
+					GroupVersion: "monitoring.coreos.com/v1",
+					APIResources: []metav1.APIResource{{Name: "servicemonitors", Kind: "ServiceMonitor"}},
+				},
+			},
+		},
+		FakedServerVersion: &version.Info{GitVersion: "v1.14.8-gke.12", Major: "1", Minor: "14+"},
+	}
+
+	caps, err := getCapabilities(&fakeGetter{memory.NewMemCacheClient(dc)}, &cluster.Info{Name: "business"})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.14.8-gke.12", caps.KubeVersion.Version)
+	assert.True(t, caps.APIVersions.Has("monitoring.coreos.com/v1"))
+	assert.True(t, caps.APIVersions.Has("apps/v1/Deployment"))
+	assert.False(t, caps.APIVersions.Has("extensions/v1beta1"))
+}
+
+func TestCheckKubeVersion(t *testing.T) {
+	caps := &chartutil.Capabilities{KubeVersion: chartutil.KubeVersion{Version: "v1.14.8-gke.12"}}
+	info := &cluster.Info{Name: "business"}
+
+	ch := &chart.Chart{Metadata: &chart.Metadata{Name: "app", KubeVersion: ">=1.14.0"}}
+	assert.Nil(t, checkKubeVersion(ch, caps, info))
+
+	sub := &chart.Chart{Metadata: &chart.Metadata{Name: "sub", KubeVersion: ">=1.16.0"}}
+	ch.AddDependency(sub)
+	err := checkKubeVersion(ch, caps, info)
+	assert.NotNil(t, err)
+	assert.Equal(t, "chart sub requires kubernetes version >=1.16.0, but cluster business is running v1.14.8-gke.12", err.Error())
+
+	// nested sub charts
+	sub.Metadata.KubeVersion = ""
+	nested := &chart.Chart{Metadata: &chart.Metadata{Name: "nested", KubeVersion: ">=1.15.0"}}
+	sub.AddDependency(nested)
+	err = checkKubeVersion(ch, caps, info)
+	assert.NotNil(t, err)
+	assert.Equal(t, "chart nested requires kubernetes version >=1.15.0, but cluster business is running v1.14.8-gke.12", err.Error())
+}
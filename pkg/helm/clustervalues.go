@@ -0,0 +1,159 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/component-base/regex"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"helm.sh/helm/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ReservedValuesKey is the top level key in values reserved by captain
+	ReservedValuesKey = "captain"
+
+	// clusterInfoKey is the key under ReservedValuesKey for the metadata of the target cluster, it's
+	// always set by captain
+	clusterInfoKey = "cluster"
+
+	// clusterValuesKey is the key under ReservedValuesKey for per-cluster values. It's a map from cluster
+	// name or label selector to values.
+	clusterValuesKey = "clusterValues"
+)
+
+// popClusterValues removes the per-cluster values from values and returns them. The reserved key will also
+// be removed if nothing left in it.
+func popClusterValues(values Values) (Values, error) {
+	reserved, ok := values[ReservedValuesKey].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	data, ok := reserved[clusterValuesKey]
+	if !ok {
+		return nil, nil
+	}
+	delete(reserved, clusterValuesKey)
+	if len(reserved) == 0 {
+		delete(values, ReservedValuesKey)
+	}
+
+	result, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s.%s should be a map from cluster name or selector to values", ReservedValuesKey, clusterValuesKey)
+	}
+	return result, nil
+}
+
+// ValidateReservedValues checks the values under the reserved key in the spec of a HelmRequest, such as
+// the per-cluster values and the extended sources, are well formed
+func ValidateReservedValues(hr *v1alpha1.HelmRequest) error {
+	sources, err := getExtendedSources(hr)
+	if err != nil {
+		return err
+	}
+	for i := range sources {
+		if err := validateExtendedSource(&sources[i]); err != nil {
+			return fmt.Errorf("invalid values source %d: %s", i, err.Error())
+		}
+	}
+
+	values := Values(hr.Spec.HelmValues.DeepCopy().Values)
+	clusterValues, err := popClusterValues(values)
+	if err != nil || clusterValues == nil {
+		return err
+	}
+	_, err = getClusterValues(clusterValues, &cluster.Info{})
+	return err
+}
+
+// getClusterValues merges the per-cluster values which match the cluster. A key is treated as a cluster name
+// if it is a valid one, otherwise it's parsed as a label selector of the Cluster resource.
+// The ones matched by selector are merged first in order of their keys, then the one matched by name, so the
+// more specific one wins.
+func getClusterValues(clusterValues Values, info *cluster.Info) (Values, error) {
+	var keys []string
+	for key := range clusterValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := Values{}
+	var byName Values
+	for _, key := range keys {
+		v, ok := clusterValues[key].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("values for cluster %s should be a map", key)
+		}
+
+		if regex.IsValidResourceName(key) {
+			if key == info.GetName() {
+				byName = v
+			}
+			continue
+		}
+
+		selector, err := labels.Parse(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster selector %s: %s", key, err.Error())
+		}
+		if selector.Matches(labels.Set(info.Labels)) {
+			values = mergeValues(values, copyValues(v))
+		}
+	}
+
+	if byName != nil {
+		values = mergeValues(values, copyValues(byName))
+	}
+	return values, nil
+}
+
+// copyValues returns a deep copy of values, so merge will not change the origin one
+func copyValues(v Values) Values {
+	result := Values{}
+	for key, value := range v {
+		if m, ok := value.(map[string]interface{}); ok {
+			result[key] = copyValues(m)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// setClusterInfo injects the metadata of the target cluster into values, as captain.cluster. The version
+// is set by setClusterVersion after the capabilities of the cluster are discovered.
+func setClusterInfo(values Values, info *cluster.Info) Values {
+	labels := map[string]interface{}{}
+	for k, v := range info.Labels {
+		labels[k] = v
+	}
+	clusterInfo := map[string]interface{}{
+		"name":     info.GetName(),
+		"endpoint": info.Endpoint,
+		"labels":   labels,
+		"version":  "",
+	}
+
+	reserved, ok := values[ReservedValuesKey].(map[string]interface{})
+	if !ok {
+		reserved = map[string]interface{}{}
+		values[ReservedValuesKey] = reserved
+	}
+	reserved[clusterInfoKey] = clusterInfo
+	return values
+}
+
+// setClusterVersion sets the kubernetes version of the target cluster in the injected metadata, as
+// captain.cluster.version
+func setClusterVersion(values Values, caps *chartutil.Capabilities) {
+	reserved, ok := values[ReservedValuesKey].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if clusterInfo, ok := reserved[clusterInfoKey].(map[string]interface{}); ok {
+		clusterInfo["version"] = caps.KubeVersion.Version
+	}
+}
@@ -0,0 +1,76 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chartutil"
+)
+
+func TestPopClusterValues(t *testing.T) {
+	values := Values{
+		"replicas": 1,
+		"captain": map[string]interface{}{
+			"clusterValues": map[string]interface{}{
+				"business": map[string]interface{}{"replicas": 3},
+			},
+		},
+	}
+	cv, err := popClusterValues(values)
+	assert.Nil(t, err)
+	assert.Equal(t, Values{"business": map[string]interface{}{"replicas": 3}}, cv)
+	assert.Equal(t, Values{"replicas": 1}, values)
+
+	_, err = popClusterValues(Values{"captain": map[string]interface{}{"clusterValues": "x"}})
+	assert.NotNil(t, err)
+}
+
+func TestGetClusterValues(t *testing.T) {
+	cv := Values{
+		"region=cn": map[string]interface{}{
+			"image": map[string]interface{}{"registry": "cn.example.com", "tag": "v1"},
+		},
+		"business": map[string]interface{}{
+			"image": map[string]interface{}{"registry": "business.example.com"},
+		},
+		"other": map[string]interface{}{"replicas": 5},
+	}
+
+	values, err := getClusterValues(cv, &cluster.Info{Name: "business", Labels: map[string]string{"region": "cn"}})
+	assert.Nil(t, err)
+	assert.Equal(t, Values{
+		"image": map[string]interface{}{"registry": "business.example.com", "tag": "v1"},
+	}, values)
+
+	values, err = getClusterValues(cv, &cluster.Info{Name: "_default", Alias: "other"})
+	assert.Nil(t, err)
+	assert.Equal(t, Values{"replicas": 5}, values)
+
+	_, err = getClusterValues(Values{"a in (b": map[string]interface{}{}}, &cluster.Info{})
+	assert.NotNil(t, err)
+}
+
+func TestSetClusterInfo(t *testing.T) {
+	values := Values{"captain": map[string]interface{}{"foo": "bar"}}
+	info := &cluster.Info{
+		Name:     "business",
+		Endpoint: "https://10.0.0.1:6443",
+		Labels:   map[string]string{"region": "cn"},
+	}
+	values = setClusterInfo(values, info)
+	assert.Equal(t, Values{
+		"captain": map[string]interface{}{
+			"foo": "bar",
+			"cluster": map[string]interface{}{
+				"name":     "business",
+				"endpoint": "https://10.0.0.1:6443",
+				"labels":   map[string]interface{}{"region": "cn"},
+				"version":  "",
+			},
+		},
+	}, values)
+
+	setClusterVersion(values, &chartutil.Capabilities{KubeVersion: chartutil.KubeVersion{Version: "v1.16.2"}})
+	assert.Equal(t, "v1.16.2", values["captain"].(map[string]interface{})["cluster"].(map[string]interface{})["version"])
+}
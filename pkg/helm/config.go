@@ -5,9 +5,7 @@ import (
 
 	"github.com/alauda/captain/pkg/cluster"
 	newkube "github.com/alauda/captain/pkg/kube"
-	"github.com/alauda/captain/pkg/kubeconfig"
 	releaseclient "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
-	"helm.sh/helm/pkg/kube"
 
 	"github.com/alauda/captain/pkg/release/storagedriver"
 	"helm.sh/helm/pkg/action"
@@ -17,7 +15,6 @@ import (
 	"helm.sh/helm/pkg/repo"
 	"helm.sh/helm/pkg/storage"
 	"helm.sh/helm/pkg/storage/driver"
-	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/klog"
 )
 
@@ -53,31 +50,23 @@ func Init() {
 	}
 }
 
-// getNamespace get the namespaces from ..... This may be a little unnecessary, may be we can just
-// use the one we know.
-func getNamespace(flags *genericclioptions.ConfigFlags) string {
-	if ns, _, err := flags.ToRawKubeConfigLoader().Namespace(); err == nil {
-		return ns
-	}
-	return "alauda-system"
-}
-
 // newActionConfig create a config for all the actions(install,delete,update...)
 // allNamespaces is always set to false for now,
-// default storage driver is Release now
-func newActionConfig(info *cluster.Info) (*action.Configuration, error) {
-	cfg, err := kubeconfig.UpdateKubeConfig(info)
+// default storage driver is Release now. The clients are generated from the cluster info in memory.
+// The manifests are built with the options, nil means Strict.
+func newActionConfig(info *cluster.Info, options *newkube.Options) (*action.Configuration, error) {
+	getter, err := info.ToRESTClientGetter()
 	if err != nil {
 		return nil, err
 	}
-	cfg.Namespace = info.Namespace
-
-	cfgFlags := kube.GetConfig(cfg.Path, cfg.Context, cfg.Namespace)
-	kc := newkube.New(cfgFlags)
+	kc := newkube.New(getter, options)
 	// hope it works
 	kc.Log = klog.Infof
 
-	namespace := getNamespace(cfgFlags)
+	namespace, _, err := getter.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, err
+	}
 
 	relClientSet, err := releaseclient.NewForConfig(info.ToRestConfig())
 	if err != nil {
@@ -99,9 +88,17 @@ func newActionConfig(info *cluster.Info) (*action.Configuration, error) {
 	}
 
 	return &action.Configuration{
-		RESTClientGetter: cfgFlags,
+		RESTClientGetter: getter,
 		KubeClient:       kc,
 		Releases:         store,
 		Log:              klog.Infof,
 	}, nil
 }
+
+// redactReleaseConfig masks the values decrypted by sops in the releases stored by cfg, the chart is still
+// rendered with the decrypted values, which only live in memory
+func redactReleaseConfig(cfg *action.Configuration, r *redactor) {
+	if d, ok := cfg.Releases.Driver.(*storagedriver.Releases); ok {
+		d.ConfigFilter = r.redactDecrypted
+	}
+}
@@ -0,0 +1,59 @@
+package helm
+
+import (
+	"fmt"
+
+	"github.com/alauda/captain/pkg/sops"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// decryptionKeySecret is the namespace and name of the secret which contains the keys to decrypt values
+var decryptionKeySecret struct {
+	namespace string
+	name      string
+}
+
+// SetDecryptionKeySecret set the secret which contains the keys to decrypt values encrypted by sops
+func SetDecryptionKeySecret(namespace, name string) {
+	decryptionKeySecret.namespace = namespace
+	decryptionKeySecret.name = name
+}
+
+// decryptValues decrypts the inline values of HelmRequest if they are encrypted by sops, the keys are read from
+// the decryption key secret every time, so they can be rotated without restart. The decrypted values only live
+// in memory, never log them.
+func decryptValues(values Values, client kubernetes.Interface) (Values, bool, error) {
+	return decryptDocument(nil, values, client)
+}
+
+// decryptDocument decrypts values parsed from data, a yaml document read from a ConfigMap or Secret, the MAC
+// is verified in the order of the document. If data is nil, the keys of values must be encrypted in sorted order.
+func decryptDocument(data []byte, values Values, client kubernetes.Interface) (Values, bool, error) {
+	if !sops.IsEncrypted(values) {
+		return values, false, nil
+	}
+	if decryptionKeySecret.name == "" {
+		return nil, true, fmt.Errorf("values are encrypted, but no decryption key secret configured")
+	}
+
+	secret, err := client.CoreV1().Secrets(decryptionKeySecret.namespace).Get(decryptionKeySecret.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, true, fmt.Errorf("get decryption key secret error: %s", err.Error())
+	}
+	keys, err := sops.ParseKeys(secret.Data)
+	if err != nil {
+		return nil, true, err
+	}
+
+	var result Values
+	if data == nil {
+		result, err = sops.Decrypt(values, keys)
+	} else {
+		result, err = sops.DecryptDocument(data, values, keys)
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("decrypt values error: %s", err.Error())
+	}
+	return result, true, nil
+}
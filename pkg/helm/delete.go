@@ -1,9 +1,9 @@
 package helm
 
 import (
-	"strings"
-
 	"github.com/alauda/captain/pkg/cluster"
+	newkube "github.com/alauda/captain/pkg/kube"
+	"github.com/alauda/captain/pkg/util"
 	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
 	"github.com/pkg/errors"
 	"helm.sh/helm/pkg/action"
@@ -12,16 +12,19 @@ import (
 )
 
 // Delete delete a Release from a cluster
+// If the HelmRequest is annotated with keep-history, the release records are kept and marked as uninstalled
 func Delete(hr *v1alpha1.HelmRequest, info *cluster.Info) error {
 
 	name := getReleaseName(hr)
 
-	cfg, err := newActionConfig(info)
+	// the release should be able to be deleted even if some of it's APIs are gone
+	cfg, err := newActionConfig(info, &newkube.Options{BuildPolicy: newkube.BuildIgnoreErrors, CRDPolicy: getCRDPolicy(hr)})
 	if err != nil {
 		return err
 	}
 
 	client := action.NewUninstall(cfg)
+	client.KeepHistory = util.IsAnnotationTrue(hr, util.KeepHistoryKey)
 
 	res, err := client.Run(name)
 	if err != nil {
@@ -30,12 +33,6 @@ func Delete(hr *v1alpha1.HelmRequest, info *cluster.Info) error {
 			return nil
 		}
 
-		// if we cannot access the target cluster, the helmrequest should be able to be deleted
-		if strings.HasSuffix(err.Error(), "EOF") || strings.Contains(err.Error(), "connect: connection refused") {
-			klog.Warningf("target cluster %s cannot access when delete helmrequest %s", hr.Spec.ClusterName, hr.GetName())
-			return nil
-		}
-
 		return err
 	}
 	if res != nil && res.Info != "" {
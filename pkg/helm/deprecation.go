@@ -0,0 +1,253 @@
+package helm
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chartutil"
+	"helm.sh/helm/pkg/engine"
+	"helm.sh/helm/pkg/releaseutil"
+	"k8s.io/klog"
+)
+
+// deprecatedAPI is a kubernetes API which is deprecated or removed in some version
+type deprecatedAPI struct {
+	// DeprecatedIn and RemovedIn are the kubernetes minor versions, like 1.16. RemovedIn may be empty
+	DeprecatedIn string
+	RemovedIn    string
+	// Replacement is the API to use instead
+	Replacement string
+}
+
+// deprecatedAPIs is the built-in table of the deprecated APIs, by apiVersion/Kind
+var deprecatedAPIs = map[string]deprecatedAPI{
+	"extensions/v1beta1/Deployment":        {"1.9", "1.16", "apps/v1"},
+	"extensions/v1beta1/DaemonSet":         {"1.9", "1.16", "apps/v1"},
+	"extensions/v1beta1/ReplicaSet":        {"1.9", "1.16", "apps/v1"},
+	"extensions/v1beta1/NetworkPolicy":     {"1.9", "1.16", "networking.k8s.io/v1"},
+	"extensions/v1beta1/PodSecurityPolicy": {"1.11", "1.16", "policy/v1beta1"},
+	"extensions/v1beta1/Ingress":           {"1.14", "1.22", "networking.k8s.io/v1"},
+	"apps/v1beta1/Deployment":              {"1.9", "1.16", "apps/v1"},
+	"apps/v1beta1/StatefulSet":             {"1.9", "1.16", "apps/v1"},
+	"apps/v1beta2/Deployment":              {"1.9", "1.16", "apps/v1"},
+	"apps/v1beta2/StatefulSet":             {"1.9", "1.16", "apps/v1"},
+	"apps/v1beta2/DaemonSet":               {"1.9", "1.16", "apps/v1"},
+	"apps/v1beta2/ReplicaSet":              {"1.9", "1.16", "apps/v1"},
+
+	"networking.k8s.io/v1beta1/Ingress":                                     {"1.19", "1.22", "networking.k8s.io/v1"},
+	"networking.k8s.io/v1beta1/IngressClass":                                {"1.19", "1.22", "networking.k8s.io/v1"},
+	"apiextensions.k8s.io/v1beta1/CustomResourceDefinition":                 {"1.16", "1.22", "apiextensions.k8s.io/v1"},
+	"apiregistration.k8s.io/v1beta1/APIService":                             {"1.19", "1.22", "apiregistration.k8s.io/v1"},
+	"admissionregistration.k8s.io/v1beta1/MutatingWebhookConfiguration":     {"1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	"admissionregistration.k8s.io/v1beta1/ValidatingWebhookConfiguration":   {"1.16", "1.22", "admissionregistration.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/ClusterRole":                         {"1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/ClusterRoleBinding":                  {"1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/Role":                                {"1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	"rbac.authorization.k8s.io/v1beta1/RoleBinding":                         {"1.17", "1.22", "rbac.authorization.k8s.io/v1"},
+	"scheduling.k8s.io/v1beta1/PriorityClass":                               {"1.14", "1.22", "scheduling.k8s.io/v1"},
+	"coordination.k8s.io/v1beta1/Lease":                                     {"1.14", "1.22", "coordination.k8s.io/v1"},
+	"certificates.k8s.io/v1beta1/CertificateSigningRequest":                 {"1.19", "1.22", "certificates.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/CSIDriver":                                      {"1.19", "1.22", "storage.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/CSINode":                                        {"1.17", "1.22", "storage.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/StorageClass":                                   {"1.6", "1.22", "storage.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/VolumeAttachment":                               {"1.13", "1.22", "storage.k8s.io/v1"},
+	"batch/v1beta1/CronJob":                                                 {"1.21", "1.25", "batch/v1"},
+	"policy/v1beta1/PodDisruptionBudget":                                    {"1.21", "1.25", "policy/v1"},
+	"policy/v1beta1/PodSecurityPolicy":                                      {"1.21", "1.25", ""},
+	"autoscaling/v2beta1/HorizontalPodAutoscaler":                           {"1.22", "1.25", "autoscaling/v2"},
+	"autoscaling/v2beta2/HorizontalPodAutoscaler":                           {"1.23", "1.26", "autoscaling/v2"},
+	"discovery.k8s.io/v1beta1/EndpointSlice":                                {"1.21", "1.25", "discovery.k8s.io/v1"},
+	"events.k8s.io/v1beta1/Event":                                           {"1.19", "1.25", "events.k8s.io/v1"},
+	"node.k8s.io/v1beta1/RuntimeClass":                                      {"1.20", "1.25", "node.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta1/FlowSchema":                       {"1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta1/PriorityLevelConfiguration":       {"1.23", "1.26", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta2/FlowSchema":                       {"1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	"flowcontrol.apiserver.k8s.io/v1beta2/PriorityLevelConfiguration":       {"1.26", "1.29", "flowcontrol.apiserver.k8s.io/v1"},
+	"storage.k8s.io/v1beta1/CSIStorageCapacity":                             {"1.24", "1.27", "storage.k8s.io/v1"},
+	"admissionregistration.k8s.io/v1alpha1/ValidatingAdmissionPolicy":       {"1.28", "1.30", "admissionregistration.k8s.io/v1"},
+	"admissionregistration.k8s.io/v1beta1/ValidatingAdmissionPolicy":        {"1.30", "1.32", "admissionregistration.k8s.io/v1"},
+	"admissionregistration.k8s.io/v1beta1/ValidatingAdmissionPolicyBinding": {"1.30", "1.32", "admissionregistration.k8s.io/v1"},
+}
+
+// APIWarning is an object of the chart which uses a deprecated API, or an API not served by the target cluster
+type APIWarning struct {
+	// Object is the kind and name of the object, like Deployment/nginx
+	Object     string
+	APIVersion string
+	// Removed means the API is removed in the target cluster's version, or not served by it
+	Removed bool
+	Message string
+}
+
+// APIWarningHandler is called with the API warnings found before the chart is installed or upgraded, it's
+// called with nil if there is none
+type APIWarningHandler func(warnings []APIWarning)
+
+// manifestHead is the part of an object we need to check it's API
+type manifestHead struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	// Spec is only used for CRDs
+	Spec struct {
+		Group    string `json:"group"`
+		Version  string `json:"version"`
+		Versions []struct {
+			Name string `json:"name"`
+		} `json:"versions"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+	} `json:"spec"`
+}
+
+// renderManifestHeads renders the chart like helm does, and parses the head of every object, including the
+// CRDs in the crds/ directory
+func renderManifestHeads(ch *chart.Chart, values chartutil.Values, options chartutil.ReleaseOptions,
+	caps *chartutil.Capabilities) ([]manifestHead, error) {
+	if err := chartutil.ProcessDependencies(ch, values); err != nil {
+		return nil, err
+	}
+	vals, err := chartutil.ToRenderValues(ch, values, options, caps)
+	if err != nil {
+		return nil, err
+	}
+	files, err := engine.Render(ch, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []string
+	for _, f := range ch.CRDs() {
+		contents = append(contents, string(f.Data))
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.HasSuffix(name, "NOTES.txt") || strings.HasPrefix(path.Base(name), "_") {
+			continue
+		}
+		contents = append(contents, files[name])
+	}
+
+	var heads []manifestHead
+	for _, content := range contents {
+		docs := releaseutil.SplitManifests(content)
+		var keys []string
+		for k := range docs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			var head manifestHead
+			if err := yaml.Unmarshal([]byte(docs[k]), &head); err != nil || head.Kind == "" {
+				continue
+			}
+			heads = append(heads, head)
+		}
+	}
+	return heads, nil
+}
+
+// checkAPIs checks the API of every object against the target cluster's capabilities and the deprecated
+// APIs table. The custom resources whose CRDs are in the chart are not checked, they are not served yet
+// on the first install.
+func checkAPIs(heads []manifestHead, caps *chartutil.Capabilities) []APIWarning {
+	var clusterVersion *semver.Version
+	if v, err := semver.NewVersion(caps.KubeVersion.Version); err == nil {
+		clusterVersion = v
+	}
+
+	crds := map[string]bool{}
+	for _, h := range heads {
+		if h.Kind != "CustomResourceDefinition" {
+			continue
+		}
+		if h.Spec.Version != "" {
+			crds[path.Join(h.Spec.Group, h.Spec.Version, h.Spec.Names.Kind)] = true
+		}
+		for _, v := range h.Spec.Versions {
+			crds[path.Join(h.Spec.Group, v.Name, h.Spec.Names.Kind)] = true
+		}
+	}
+
+	var warnings []APIWarning
+	for _, h := range heads {
+		id := path.Join(h.APIVersion, h.Kind)
+		object := h.Kind + "/" + h.Metadata.Name
+		served := caps.APIVersions.Has(id) || crds[id]
+
+		if api, ok := deprecatedAPIs[id]; ok && isVersionAtLeast(clusterVersion, api.DeprecatedIn) {
+			removed := api.RemovedIn != "" && isVersionAtLeast(clusterVersion, api.RemovedIn)
+			message := fmt.Sprintf("%s uses %s, which is deprecated in kubernetes %s", object, h.APIVersion, api.DeprecatedIn)
+			if api.RemovedIn != "" {
+				message += fmt.Sprintf(" and removed in %s", api.RemovedIn)
+			}
+			if api.Replacement != "" {
+				message += fmt.Sprintf(", use %s instead", api.Replacement)
+			}
+			warnings = append(warnings, APIWarning{Object: object, APIVersion: h.APIVersion, Removed: removed || !served, Message: message})
+			continue
+		}
+
+		if !served {
+			warnings = append(warnings, APIWarning{
+				Object:     object,
+				APIVersion: h.APIVersion,
+				Removed:    true,
+				Message:    fmt.Sprintf("%s uses %s, which is not served by the cluster", object, id),
+			})
+		}
+	}
+	return warnings
+}
+
+// isVersionAtLeast checks if the cluster version is at least the minor version, like 1.16. An unknown cluster
+// version is treated as the latest one.
+func isVersionAtLeast(version *semver.Version, minor string) bool {
+	if version == nil {
+		return true
+	}
+	target, err := semver.NewVersion(minor)
+	if err != nil {
+		return false
+	}
+	return version.Major() > target.Major() || (version.Major() == target.Major() && version.Minor() >= target.Minor())
+}
+
+// checkDeprecatedAPIs checks the APIs used by the chart before it's installed or upgraded, the warnings are
+// passed to the handler. If the policy of the HelmRequest is Block or BlockDeprecated, an error is returned
+// for the removed APIs or all of the warnings.
+func checkDeprecatedAPIs(hr *v1alpha1.HelmRequest, heads []manifestHead, caps *chartutil.Capabilities,
+	info *cluster.Info, handler APIWarningHandler) error {
+	warnings := checkAPIs(heads, caps)
+	if handler != nil {
+		handler(warnings)
+	}
+
+	policy := util.GetAnnotation(hr, util.DeprecatedAPIPolicyKey)
+	var blocking []string
+	for _, w := range warnings {
+		klog.Warningf("api check for helmrequest %s on cluster %s: %s", hr.GetName(), info.GetName(), w.Message)
+		if policy == util.DeprecatedAPIPolicyBlockDeprecated || (policy == util.DeprecatedAPIPolicyBlock && w.Removed) {
+			blocking = append(blocking, w.Message)
+		}
+	}
+	if len(blocking) > 0 {
+		return fmt.Errorf("sync to cluster %s is blocked by the deprecated APIs: %s", info.GetName(), strings.Join(blocking, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chartutil"
+)
+
+const testCRD = `apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: crontabs.stable.example.com
+spec:
+  group: stable.example.com
+  versions:
+  - name: v1
+  names:
+    kind: CronTab
+`
+
+const testTemplate = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: {{ .Release.Name }}
+---
+apiVersion: stable.example.com/v1
+kind: CronTab
+metadata:
+  name: {{ .Release.Name }}
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: {{ .Release.Name }}
+`
+
+func TestCheckAPIs(t *testing.T) {
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "0.1.0"},
+		Templates: []*chart.File{
+			{Name: "templates/all.yaml", Data: []byte(testTemplate)},
+			{Name: "templates/NOTES.txt", Data: []byte("kind: Secret")},
+		},
+		Files: []*chart.File{{Name: "crds/crontab.yaml", Data: []byte(testCRD)}},
+	}
+	caps := &chartutil.Capabilities{
+		KubeVersion: chartutil.KubeVersion{Version: "v1.16.3"},
+		APIVersions: chartutil.VersionSet{"apps/v1/Deployment", "policy/v1beta1/PodDisruptionBudget",
+			"apiextensions.k8s.io/v1beta1/CustomResourceDefinition"},
+	}
+
+	heads, err := renderManifestHeads(ch, chartutil.Values{}, chartutil.ReleaseOptions{Name: "demo"}, caps)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(heads))
+	assert.Equal(t, "demo", heads[1].Metadata.Name)
+
+	warnings := checkAPIs(heads, caps)
+	assert.Equal(t, []APIWarning{
+		{
+			Object:     "CustomResourceDefinition/crontabs.stable.example.com",
+			APIVersion: "apiextensions.k8s.io/v1beta1",
+			Message: "CustomResourceDefinition/crontabs.stable.example.com uses apiextensions.k8s.io/v1beta1, which " +
+				"is deprecated in kubernetes 1.16 and removed in 1.22, use apiextensions.k8s.io/v1 instead",
+		},
+		{
+			Object:     "Deployment/demo",
+			APIVersion: "extensions/v1beta1",
+			Removed:    true,
+			Message:    "Deployment/demo uses extensions/v1beta1, which is deprecated in kubernetes 1.9 and removed in 1.16, use apps/v1 instead",
+		},
+		{
+			Object:     "ServiceMonitor/demo",
+			APIVersion: "monitoring.coreos.com/v1",
+			Removed:    true,
+			Message:    "ServiceMonitor/demo uses monitoring.coreos.com/v1/ServiceMonitor, which is not served by the cluster",
+		},
+	}, warnings)
+}
+
+func TestCheckAPIsByVersion(t *testing.T) {
+	caps := &chartutil.Capabilities{KubeVersion: chartutil.KubeVersion{Version: "v1.15.11-gke.5"}}
+	heads := []manifestHead{{APIVersion: "extensions/v1beta1", Kind: "Ingress"}}
+	caps.APIVersions = chartutil.VersionSet{"extensions/v1beta1/Ingress"}
+	warnings := checkAPIs(heads, caps)
+	assert.Equal(t, 1, len(warnings))
+	assert.False(t, warnings[0].Removed)
+
+	// not deprecated yet
+	caps.KubeVersion.Version = "v1.13.0"
+	assert.Equal(t, 0, len(checkAPIs(heads, caps)))
+}
@@ -0,0 +1,32 @@
+package helm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"helm.sh/helm/pkg/helmpath"
+)
+
+// keyringPath is the local path of a keyring retrieved from secret
+func keyringPath(name string) string {
+	return filepath.Join(helmpath.CachePath("keyrings"), name+".gpg")
+}
+
+// SaveKeyring writes the keyring data to local cache and returns it's path, which can be used
+// as the keyring to verify charts. The file will only be rewritten if the data changed.
+func SaveKeyring(name string, data []byte) (string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := keyringPath(name)
+	if old, err := ioutil.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,230 @@
+package helm
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alauda/captain/pkg/sops"
+)
+
+const (
+	// RedactedValue replaces the sensitive values in logs and events
+	RedactedValue = "******"
+
+	// minRedactLength is the min length of a sensitive string to be scrubbed from messages. Shorter ones
+	// (like "true" or "1") are too common, scrubbing them only makes the messages unreadable.
+	minRedactLength = 6
+)
+
+// redactKeyPatterns are the patterns of the keys whose values are sensitive, matched case-insensitively
+// as sub strings of the keys
+var redactKeyPatterns = []string{"password", "token", "key"}
+
+// SetRedactKeyPatterns set the patterns of the sensitive values keys, empty patterns are ignored
+func SetRedactKeyPatterns(patterns []string) {
+	var result []string
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	redactKeyPatterns = result
+}
+
+// isSensitiveKey checks if the key of a value matches any of the redact key patterns
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range redactKeyPatterns {
+		if strings.Contains(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactor collects the sensitive strings in the values of a HelmRequest, which are values from secrets,
+// values decrypted by sops and values whose keys are sensitive. They will be masked in the values and
+// messages we logged or sent as events. A nil redactor redacts nothing.
+type redactor struct {
+	secrets map[string]bool
+	// decrypted are the paths of the values decrypted by sops, formatted by pathKey
+	decrypted map[string]bool
+}
+
+func newRedactor() *redactor {
+	return &redactor{secrets: map[string]bool{}, decrypted: map[string]bool{}}
+}
+
+// addDecrypted marks the values encrypted by sops as sensitive, and remembers their paths so they can be
+// kept out of the stored release by redactDecrypted. The values not encrypted, like the ones with the
+// unencrypted suffix, are kept as they are.
+func (r *redactor) addDecrypted(encrypted, decrypted Values) {
+	if r == nil {
+		return
+	}
+	for _, path := range sops.EncryptedPaths(encrypted) {
+		r.decrypted[pathKey(path)] = true
+		if s, ok := valueAt(decrypted, path).(string); ok && s != "" {
+			r.secrets[s] = true
+		}
+	}
+}
+
+// pathKey formats the path of a value, the keys are joined by NUL which is unlikely in a key
+func pathKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+// valueAt returns the value at path, nil if not found. The index of list items is a decimal number.
+func valueAt(value interface{}, path []string) interface{} {
+	for _, p := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// redactDecrypted returns a copy of values, the values at the paths decrypted by sops are masked. It's used
+// for the values stored in the release, the chart is still rendered with the decrypted values in memory.
+func (r *redactor) redactDecrypted(values Values) Values {
+	if r == nil || len(r.decrypted) == 0 {
+		return values
+	}
+	return r.redactDecryptedValue(values, nil).(map[string]interface{})
+}
+
+func (r *redactor) redactDecryptedValue(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = r.redactDecryptedValue(item, append(path[:len(path):len(path)], k))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = r.redactDecryptedValue(item, append(path[:len(path):len(path)], strconv.Itoa(i)))
+		}
+		return result
+	case nil:
+		return nil
+	default:
+		if r.decrypted[pathKey(path)] {
+			return RedactedValue
+		}
+		return v
+	}
+}
+
+// addSecret marks all the string values as sensitive, used for values from secrets or decrypted
+func (r *redactor) addSecret(values Values) {
+	if r == nil {
+		return
+	}
+	r.add(values, true)
+}
+
+// addValues marks the string values whose keys match the redact key patterns as sensitive
+func (r *redactor) addValues(values Values) {
+	if r == nil {
+		return
+	}
+	r.add(values, false)
+}
+
+func (r *redactor) add(value interface{}, sensitive bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			r.add(item, sensitive || isSensitiveKey(k))
+		}
+	case []interface{}:
+		for _, item := range v {
+			r.add(item, sensitive)
+		}
+	case string:
+		if sensitive && v != "" {
+			r.secrets[v] = true
+		}
+	}
+}
+
+// redactValues returns a copy of values, the sensitive values are masked
+func (r *redactor) redactValues(values Values) Values {
+	return r.redactValue(values, false).(map[string]interface{})
+}
+
+func (r *redactor) redactValue(value interface{}, sensitive bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = r.redactValue(item, sensitive || isSensitiveKey(k))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = r.redactValue(item, sensitive)
+		}
+		return result
+	case nil:
+		return nil
+	default:
+		if sensitive {
+			return RedactedValue
+		}
+		if s, ok := v.(string); ok && r != nil && r.secrets[s] {
+			return RedactedValue
+		}
+		return v
+	}
+}
+
+// redact masks all the sensitive strings in the message
+func (r *redactor) redact(message string) string {
+	if r == nil {
+		return message
+	}
+	var secrets []string
+	for s := range r.secrets {
+		if len(s) >= minRedactLength {
+			secrets = append(secrets, s)
+		}
+	}
+	// replace the longer ones first, in case one secret contains another
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	for _, s := range secrets {
+		message = strings.Replace(message, s, RedactedValue, -1)
+	}
+	return message
+}
+
+// redactError masks the sensitive strings in the error message, the error is returned as it is if
+// there is nothing to mask, so it can still be checked by type
+func (r *redactor) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := r.redact(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,108 @@
+package helm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+)
+
+func TestRedactValues(t *testing.T) {
+	r := newRedactor()
+	r.addSecret(Values{"db": map[string]interface{}{"host": "db.example.com"}})
+	values := Values{
+		"db": map[string]interface{}{
+			"host":     "db.example.com",
+			"port":     5432,
+			"Password": "s3cr3t-pass",
+		},
+		"auth": map[string]interface{}{
+			"apiKeys": []interface{}{"key-a", "key-b"},
+		},
+		"image": "nginx",
+	}
+	r.addValues(values)
+
+	assert.Equal(t, Values{
+		"db": map[string]interface{}{
+			"host":     RedactedValue,
+			"port":     5432,
+			"Password": RedactedValue,
+		},
+		"auth": map[string]interface{}{
+			"apiKeys": []interface{}{RedactedValue, RedactedValue},
+		},
+		"image": "nginx",
+	}, r.redactValues(values))
+	// the original values are untouched
+	assert.Equal(t, "s3cr3t-pass", values["db"].(map[string]interface{})["Password"])
+
+	err := r.redactError(errors.New("cannot connect to db.example.com with password s3cr3t-pass"))
+	assert.Equal(t, "cannot connect to ****** with password ******", err.Error())
+
+	// short values are not scrubbed from messages
+	assert.Equal(t, "invalid key-a", r.redact("invalid key-a"))
+
+	origin := errors.New("nothing to hide")
+	assert.Equal(t, origin, r.redactError(origin))
+
+	var nilRedactor *redactor
+	nilRedactor.addSecret(values)
+	assert.Equal(t, "s3cr3t-pass", nilRedactor.redact("s3cr3t-pass"))
+}
+
+func TestSetRedactKeyPatterns(t *testing.T) {
+	defer SetRedactKeyPatterns([]string{"password", "token", "key"})
+
+	SetRedactKeyPatterns([]string{" Secret ", ""})
+	assert.True(t, isSensitiveKey("clientSecret"))
+	assert.False(t, isSensitiveKey("password"))
+}
+
+func TestRedactDecrypted(t *testing.T) {
+	r := newRedactor()
+	r.addDecrypted(Values{
+		"db": map[string]interface{}{
+			"password": "ENC[AES256_GCM,data:x,iv:y,tag:z,type:str]",
+			"port":     "ENC[AES256_GCM,data:x,iv:y,tag:z,type:int]",
+			"enabled":  true,
+		},
+		"hosts": []interface{}{"a", "ENC[AES256_GCM,data:x,iv:y,tag:z,type:str]"},
+		"sops":  map[string]interface{}{"mac": "ENC[AES256_GCM,data:x,iv:y,tag:z,type:str]"},
+	}, Values{
+		"db": map[string]interface{}{
+			"password": "s3cr3t-pass",
+			"port":     3306,
+			"enabled":  true,
+		},
+		"hosts": []interface{}{"a", "b"},
+	})
+	values := Values{
+		"db": map[string]interface{}{
+			"password": "s3cr3t-pass",
+			"port":     3306,
+			"enabled":  true,
+		},
+		"hosts":    []interface{}{"a", "b"},
+		"replicas": 3306,
+		"enabled":  true,
+		"image":    "s3cr3t-pass",
+	}
+	// only the values at the encrypted paths are masked, not the equal values elsewhere
+	assert.Equal(t, Values{
+		"db": map[string]interface{}{
+			"password": RedactedValue,
+			"port":     RedactedValue,
+			"enabled":  true,
+		},
+		"hosts":    []interface{}{"a", RedactedValue},
+		"replicas": 3306,
+		"enabled":  true,
+		"image":    "s3cr3t-pass",
+	}, r.redactDecrypted(values))
+	// the decrypted strings are still scrubbed from messages
+	assert.Equal(t, "invalid ******", r.redact("invalid s3cr3t-pass"))
+
+	// nothing decrypted
+	assert.Equal(t, values, newRedactor().redactDecrypted(values))
+}
@@ -10,6 +10,10 @@ import (
 // GetChartsForRepo retrieve charts info from a repo cache index
 // Check: can we use the generated time to do compare?
 func GetChartsForRepo(name string) (*repo.IndexFile, error) {
-	path := helmpath.CachePath("repository") + fmt.Sprintf("/%s-index.yaml", name)
-	return repo.LoadIndexFile(path)
+	return repo.LoadIndexFile(repoIndexFile(name))
+}
+
+// repoIndexFile is the path of the cached index file for a repo
+func repoIndexFile(name string) string {
+	return helmpath.CachePath("repository") + fmt.Sprintf("/%s-index.yaml", name)
 }
@@ -17,6 +17,24 @@ func AddBasicAuthRepository(name, url, username, password string) error {
 	return addRepository(name, url, username, password, "", "", "", false)
 }
 
+// GetRepository get the repo entry by name from helm
+func GetRepository(name string) (*repo.Entry, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	f, err := repo.LoadFile(helmRepositoryFile())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range f.Repositories {
+		if item.Name == name {
+			return item, nil
+		}
+	}
+	return nil, errors.Errorf("repository %s not found", name)
+}
+
 // RemoveRepository remove a repo from helm
 func RemoveRepository(name string) error {
 	lock.Lock()
@@ -35,6 +53,28 @@ func RemoveRepository(name string) error {
 	return nil
 }
 
+// AddLocalRepository add a repo which index is generated by captain, instead of downloaded from url
+func AddLocalRepository(name, url string, index *repo.IndexFile) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	f, err := repo.LoadFile(helmRepositoryFile())
+	if err != nil {
+		return err
+	}
+
+	if err := index.WriteFile(repoIndexFile(name), 0644); err != nil {
+		return err
+	}
+
+	f.Update(&repo.Entry{
+		Name: name,
+		URL:  url,
+	})
+
+	return f.WriteFile(helmRepositoryFile(), 0644)
+}
+
 // addRepository add a repo and update index ( the repo already exist, we only need to update-index part)
 func addRepository(name, url, username, password string, certFile, keyFile, caFile string, noUpdate bool) error {
 	lock.Lock()
@@ -0,0 +1,396 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+const (
+	// valuesFromKey is the key under ReservedValuesKey for the extended values sources
+	valuesFromKey = "valuesFrom"
+
+	// exportsKey is the key under ReservedValuesKey for the values a HelmRequest exports to others
+	exportsKey = "exports"
+
+	// urlTimeout is the timeout to fetch values from url
+	urlTimeout = 30 * time.Second
+
+	// maxURLValuesSize is the max size of a values file fetched from url, which is the same as the max size
+	// of a ConfigMap
+	maxURLValuesSize = 1 << 20
+)
+
+// ValuesSource is an extended values source, which cannot be expressed by the ValuesFromSource type.
+// Exactly one of the refs should be set.
+type ValuesSource struct {
+	// ConfigMapRef merges every key of a ConfigMap as string values
+	ConfigMapRef *ObjectRef `json:"configMapRef,omitempty"`
+	// SecretRef merges every key of a Secret as string values
+	SecretRef *ObjectRef `json:"secretRef,omitempty"`
+	// HelmRequestRef takes the exported values or status of another HelmRequest
+	HelmRequestRef *HelmRequestRef `json:"helmRequestRef,omitempty"`
+	// URL fetches a values file from a http(s) url
+	URL *URLSource `json:"url,omitempty"`
+
+	// TargetPath is a dot separated path the values will be nested under, default to the root
+	TargetPath string `json:"targetPath,omitempty"`
+	// FromTargetCluster reads the ConfigMap/Secret/HelmRequest from the target cluster instead of
+	// the global one
+	FromTargetCluster bool `json:"fromTargetCluster,omitempty"`
+	// Optional means errors of this source are ignored
+	Optional bool `json:"optional,omitempty"`
+}
+
+// ObjectRef refers to a ConfigMap or Secret. It lives in the namespace of the HelmRequest, or in the
+// release namespace when read from the target cluster.
+type ObjectRef struct {
+	Name string `json:"name"`
+}
+
+// HelmRequestRef refers to another HelmRequest
+type HelmRequestRef struct {
+	// Namespace default to the namespace of the HelmRequest. The HelmRequest in another namespace must
+	// allow it by the exports-to annotation
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Field is exports (default) or status. exports are the values set in captain.exports of that HelmRequest
+	Field string `json:"field,omitempty"`
+}
+
+// URLSource is a values file from url
+type URLSource struct {
+	URL string `json:"url"`
+	// Checksum is the sha256 checksum of the file, in the format of sha256:<hex>
+	Checksum string `json:"checksum"`
+}
+
+// sourceContext contains the clusters the sources are read from
+type sourceContext struct {
+	hr *v1alpha1.HelmRequest
+	// cfg is the rest config of the global cluster
+	cfg *rest.Config
+	// info is the target cluster, nil if unknown
+	info *cluster.Info
+	// skipURL skips the url sources, they may be slow to fetch
+	skipURL bool
+	// redactor collects the values from secrets, can be nil
+	redactor *redactor
+}
+
+// getExtendedSources parses the extended values sources from the values of a HelmRequest
+func getExtendedSources(hr *v1alpha1.HelmRequest) ([]ValuesSource, error) {
+	reserved, ok := hr.Spec.HelmValues.Values[ReservedValuesKey].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	data, ok := reserved[valuesFromKey]
+	if !ok {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var sources []ValuesSource
+	if err := json.Unmarshal(raw, &sources); err != nil {
+		return nil, fmt.Errorf("invalid %s.%s: %s", ReservedValuesKey, valuesFromKey, err.Error())
+	}
+	return sources, nil
+}
+
+// popExtendedSources removes the extended values sources and the exports from values, they are not used
+// by the chart
+func popExtendedSources(values Values) {
+	reserved, ok := values[ReservedValuesKey].(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(reserved, valuesFromKey)
+	delete(reserved, exportsKey)
+	if len(reserved) == 0 {
+		delete(values, ReservedValuesKey)
+	}
+}
+
+// getValuesFromExtendedSource resolves the values of one extended source, nested under it's target path
+func getValuesFromExtendedSource(ctx *sourceContext, s *ValuesSource) (Values, error) {
+	cfg := ctx.cfg
+	namespace := ctx.hr.GetNamespace()
+	if s.FromTargetCluster {
+		if ctx.info == nil {
+			return nil, fmt.Errorf("target cluster unknown")
+		}
+		cfg = ctx.info.ToRestConfig()
+		namespace = ctx.hr.Spec.Namespace
+	}
+
+	var values Values
+	var err error
+	switch {
+	case s.ConfigMapRef != nil:
+		values, err = getValuesFromWholeConfigMap(cfg, namespace, s.ConfigMapRef.Name)
+	case s.SecretRef != nil:
+		values, err = getValuesFromWholeSecret(cfg, namespace, s.SecretRef.Name)
+		if err == nil {
+			ctx.redactor.addSecret(values)
+		}
+	case s.HelmRequestRef != nil:
+		values, err = getValuesFromHelmRequest(cfg, ctx.hr.GetNamespace(), s.HelmRequestRef)
+	case s.URL != nil:
+		values, err = getValuesFromURL(s.URL)
+	default:
+		err = fmt.Errorf("no source set")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nestValues(values, s.TargetPath), nil
+}
+
+// validateExtendedSource checks exactly one source is set, and it's well formed
+func validateExtendedSource(s *ValuesSource) error {
+	count := 0
+	for _, set := range []bool{s.ConfigMapRef != nil, s.SecretRef != nil, s.HelmRequestRef != nil, s.URL != nil} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("exactly one of configMapRef, secretRef, helmRequestRef and url should be set")
+	}
+
+	if s.URL != nil {
+		if algo, sum := splitChecksum(s.URL.Checksum); algo != "sha256" || sum == "" {
+			return fmt.Errorf("a checksum in the format of sha256:<hex> is required for url")
+		}
+		if s.FromTargetCluster {
+			return fmt.Errorf("fromTargetCluster cannot be used with url")
+		}
+	}
+	if s.HelmRequestRef != nil && s.HelmRequestRef.Field != "" && s.HelmRequestRef.Field != exportsKey && s.HelmRequestRef.Field != "status" {
+		return fmt.Errorf("field of helmRequestRef should be exports or status")
+	}
+	return nil
+}
+
+// nestValues put values under a dot separated path
+func nestValues(values Values, path string) Values {
+	if path == "" {
+		return values
+	}
+	keys := strings.Split(path, ".")
+	for i := len(keys) - 1; i >= 0; i-- {
+		values = Values{keys[i]: values}
+	}
+	return values
+}
+
+func getValuesFromWholeConfigMap(cfg *rest.Config, namespace, name string) (Values, error) {
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	values := Values{}
+	for k, v := range cm.Data {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func getValuesFromWholeSecret(cfg *rest.Config, namespace, name string) (Values, error) {
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	values := Values{}
+	for k, v := range secret.Data {
+		values[k] = string(v)
+	}
+	return values, nil
+}
+
+// getValuesFromHelmRequest reads the exports or status of a HelmRequest. current is the namespace of the
+// HelmRequest reading it, the one in other namespaces is read only if it's exported to current, since it's
+// read with captain's own credentials.
+func getValuesFromHelmRequest(cfg *rest.Config, current string, ref *HelmRequestRef) (Values, error) {
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	namespace := current
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	hr, err := client.AppV1alpha1().HelmRequests(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if namespace != current && !util.IsExportedTo(hr, current) {
+		return nil, fmt.Errorf("helmrequest %s/%s is not exported to namespace %s by the %s annotation",
+			namespace, ref.Name, current, util.ExportsToKey)
+	}
+
+	switch ref.Field {
+	case "", exportsKey:
+		reserved, _ := hr.Spec.HelmValues.Values[ReservedValuesKey].(map[string]interface{})
+		exports, _ := reserved[exportsKey].(map[string]interface{})
+		return copyValues(exports), nil
+	case "status":
+		data, err := json.Marshal(hr.Status)
+		if err != nil {
+			return nil, err
+		}
+		values := Values{}
+		err = json.Unmarshal(data, &values)
+		return values, err
+	default:
+		return nil, fmt.Errorf("unknown field %s of helmrequest, should be exports or status", ref.Field)
+	}
+}
+
+func getValuesFromURL(s *URLSource) (Values, error) {
+	algo, sum := splitChecksum(s.Checksum)
+	if algo != "sha256" || sum == "" {
+		return nil, fmt.Errorf("a sha256 checksum is required for url %s", s.URL)
+	}
+
+	client := http.Client{Timeout: urlTimeout}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s error: %s", s.URL, resp.Status)
+	}
+	// read one more byte to know if it's too large
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxURLValuesSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxURLValuesSize {
+		return nil, fmt.Errorf("values from url %s are larger than %d bytes", s.URL, maxURLValuesSize)
+	}
+
+	actual := sha256.Sum256(data)
+	if hex.EncodeToString(actual[:]) != strings.ToLower(sum) {
+		return nil, fmt.Errorf("checksum mismatch for url %s", s.URL)
+	}
+
+	var values Values
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// splitChecksum splits <algo>:<sum>
+func splitChecksum(checksum string) (string, string) {
+	ss := strings.SplitN(checksum, ":", 2)
+	if len(ss) != 2 {
+		return "", ""
+	}
+	return ss[0], ss[1]
+}
+
+// getValuesFromExtendedSources merges values from all the extended sources. If skipErrors is true, the
+// sources which cannot be resolved will be skipped.
+func getValuesFromExtendedSources(ctx *sourceContext, skipErrors bool) (Values, error) {
+	sources, err := getExtendedSources(ctx.hr)
+	if err != nil {
+		return nil, err
+	}
+
+	values := Values{}
+	for i := range sources {
+		s := &sources[i]
+		if s.URL != nil && ctx.skipURL {
+			continue
+		}
+		v, err := getValuesFromExtendedSource(ctx, s)
+		if err != nil {
+			if s.Optional || skipErrors {
+				klog.Warningf("skip values source %d of %s: %s", i, ctx.hr.GetName(), err.Error())
+				continue
+			}
+			return nil, fmt.Errorf("get values from source %d error: %s", i, err.Error())
+		}
+		values = mergeValues(values, v)
+	}
+	return values, nil
+}
+
+const (
+	// SourceKindConfigMap is the kind of ConfigMap sources
+	SourceKindConfigMap = "ConfigMap"
+	// SourceKindSecret is the kind of Secret sources
+	SourceKindSecret = "Secret"
+)
+
+// SourceObject is a ConfigMap or Secret in the global cluster, the values of a HelmRequest are read from
+type SourceObject struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// String returns <kind>/<namespace>/<name>
+func (o SourceObject) String() string {
+	return fmt.Sprintf("%s/%s/%s", o.Kind, o.Namespace, o.Name)
+}
+
+// GetSourceObjects returns the ConfigMaps and Secrets in the global cluster the values of a HelmRequest
+// are read from, including the extended sources. The ones from the target cluster are not included.
+func GetSourceObjects(hr *v1alpha1.HelmRequest) []SourceObject {
+	var result []SourceObject
+	ns := hr.GetNamespace()
+	for _, s := range hr.Spec.ValuesFrom {
+		if s.ConfigMapKeyRef != nil {
+			result = append(result, SourceObject{SourceKindConfigMap, ns, s.ConfigMapKeyRef.Name})
+		}
+		if s.SecretKeyRef != nil {
+			result = append(result, SourceObject{SourceKindSecret, ns, s.SecretKeyRef.Name})
+		}
+	}
+
+	// invalid ones are rejected by the webhook, and reported when syncing
+	sources, _ := getExtendedSources(hr)
+	for _, s := range sources {
+		if s.FromTargetCluster {
+			continue
+		}
+		if s.ConfigMapRef != nil {
+			result = append(result, SourceObject{SourceKindConfigMap, ns, s.ConfigMapRef.Name})
+		}
+		if s.SecretRef != nil {
+			result = append(result, SourceObject{SourceKindSecret, ns, s.SecretRef.Name})
+		}
+	}
+	return result
+}
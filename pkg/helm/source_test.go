@@ -0,0 +1,72 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+)
+
+func TestNestValues(t *testing.T) {
+	values := Values{"a": "b"}
+	assert.Equal(t, values, nestValues(values, ""))
+	assert.Equal(t, Values{"x": Values{"y": Values{"a": "b"}}}, nestValues(values, "x.y"))
+}
+
+func TestGetValuesFromURL(t *testing.T) {
+	data := []byte("image:\n  tag: v1\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(data)
+	values, err := getValuesFromURL(&URLSource{URL: server.URL, Checksum: "sha256:" + hex.EncodeToString(sum[:])})
+	assert.Nil(t, err)
+	assert.Equal(t, Values{"image": map[string]interface{}{"tag": "v1"}}, values)
+
+	_, err = getValuesFromURL(&URLSource{URL: server.URL, Checksum: "sha256:1234"})
+	assert.NotNil(t, err)
+
+	_, err = getValuesFromURL(&URLSource{URL: server.URL})
+	assert.NotNil(t, err)
+
+	// too large, even if the checksum matches
+	data = []byte("image: " + strings.Repeat("x", maxURLValuesSize) + "\n")
+	sum = sha256.Sum256(data)
+	_, err = getValuesFromURL(&URLSource{URL: server.URL, Checksum: "sha256:" + hex.EncodeToString(sum[:])})
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "larger than"))
+}
+
+func TestGetExtendedSources(t *testing.T) {
+	hr := &v1alpha1.HelmRequest{}
+	hr.Spec.HelmValues.Values = map[string]interface{}{
+		"captain": map[string]interface{}{
+			"valuesFrom": []interface{}{
+				map[string]interface{}{
+					"configMapRef": map[string]interface{}{"name": "env"},
+					"targetPath":   "env",
+				},
+				map[string]interface{}{
+					"helmRequestRef": map[string]interface{}{"name": "db", "namespace": "infra"},
+				},
+			},
+		},
+	}
+	sources, err := getExtendedSources(hr)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sources))
+	assert.Equal(t, "env", sources[0].ConfigMapRef.Name)
+	assert.Equal(t, "env", sources[0].TargetPath)
+	assert.Equal(t, "infra", sources[1].HelmRequestRef.Namespace)
+	assert.Nil(t, ValidateReservedValues(hr))
+
+	sources[0].SecretRef = &ObjectRef{Name: "env"}
+	assert.NotNil(t, validateExtendedSource(&sources[0]))
+}
@@ -1,14 +1,16 @@
 package helm
 
 import (
-	"os"
 	"strings"
+	"time"
 
 	"github.com/alauda/captain/pkg/cluster"
+	newkube "github.com/alauda/captain/pkg/kube"
 	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
 	"github.com/pkg/errors"
 	"helm.sh/helm/pkg/action"
 	"helm.sh/helm/pkg/chart/loader"
+	"helm.sh/helm/pkg/chartutil"
 	"helm.sh/helm/pkg/cli"
 	"helm.sh/helm/pkg/release"
 	"helm.sh/helm/pkg/storage/driver"
@@ -18,19 +20,67 @@ import (
 // Sync = install + upgrade
 // When sync done, add the release note to HelmRequest status
 // inCluster info is used to retrieve config info for valuesFrom
-func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info) (*release.Release, error) {
+// If keyring is not empty, the chart must have a valid provenance file signed by one of the keys in it
+// The sensitive values are masked in the returned error, it may be sent as an event
+// The APIs used by the chart are checked before install or upgrade, the warnings are passed to handler
+// If the custom resources of the CRDs in the chart are skipped by the build policy, the sync is retried once
+// after the CRDs are established
+// The fields changed by both the chart and others in the cluster are passed to conflictHandler
+func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info, keyring string,
+	handler APIWarningHandler, conflictHandler ConflictHandler) (_ *release.Release, err error) {
+	r := newRedactor()
+	defer func() {
+		err = r.redactError(err)
+	}()
+
+	options := &newkube.Options{BuildPolicy: newkube.BuildStrict, ConflictPolicy: newkube.ConflictOverwrite}
+	defer func() {
+		if conflictHandler != nil {
+			conflictHandler(options.Conflicts())
+		}
+	}()
+	rel, err := syncOnce(hr, info, inCluster, keyring, handler, r, options)
+	if err != nil {
+		return rel, err
+	}
+	skipped := options.Skipped()
+	if len(skipped) == 0 {
+		return rel, nil
+	}
+
+	klog.Infof("resources %s of helmrequest %s are skipped, wait for the CRDs to be established", strings.Join(skipped, ", "), hr.GetName())
+	if err := newkube.WaitForCRDs(info.ToRestConfig(), options.CRDs, newkube.CRDEstablishTimeout); err != nil {
+		return nil, errors.Wrapf(err, "resources %s are skipped", strings.Join(skipped, ", "))
+	}
+	info.InvalidateDiscovery()
+	options.Reset()
+
+	// the warnings are the same, no need to report them again
+	rel, err = syncOnce(hr, info, inCluster, keyring, nil, r, options)
+	if err != nil {
+		return rel, err
+	}
+	if skipped := options.Skipped(); len(skipped) > 0 {
+		return nil, errors.Errorf("resources %s are still skipped after the CRDs are established", strings.Join(skipped, ", "))
+	}
+	return rel, nil
+}
+
+// syncOnce installs or upgrades the release, the build options are set after the chart is rendered
+func syncOnce(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info, keyring string,
+	handler APIWarningHandler, r *redactor, options *newkube.Options) (*release.Release, error) {
 	name := getReleaseName(hr)
-	out := os.Stdout
 
 	// helm settings
 	settings := cli.New()
 	settings.Debug = true
 
 	// init upgrade client
-	cfg, err := newActionConfig(info)
+	cfg, err := newActionConfig(info, options)
 	if err != nil {
 		return nil, err
 	}
+	redactReleaseConfig(cfg, r)
 	client := action.NewUpgrade(cfg)
 	// client.Force = true
 	client.Namespace = hr.Spec.Namespace
@@ -41,9 +91,10 @@ func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info)
 	if hr.Spec.Version != "" {
 		client.Version = hr.Spec.Version
 	}
+	setVerifyOptions(&client.ChartPathOptions, keyring)
 
 	// merge values
-	values, err := getValues(hr, inCluster.ToRestConfig())
+	values, err := getValues(hr, info, inCluster.ToRestConfig(), r)
 	if err != nil {
 		return nil, err
 	}
@@ -51,14 +102,14 @@ func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info)
 
 	// locate chart
 	chrt := hr.Spec.Chart
-	chartPath, err := client.ChartPathOptions.LocateChart(chrt, settings)
+	chartPath, err := locateChart(&client.ChartPathOptions, chrt, settings)
 	if err != nil {
 		klog.Errorf("locate chart %s error: %s", chartPath, err.Error())
 		// a simple string match
 		if client.Version == "" && strings.Contains(err.Error(), " no chart version found for") {
 			klog.Info("no normal version found, try using devel flag")
 			client.Version = ">0.0.0-0"
-			chartPath, err = client.ChartPathOptions.LocateChart(chrt, settings)
+			chartPath, err = locateChart(&client.ChartPathOptions, chrt, settings)
 			if err != nil {
 				return nil, err
 			}
@@ -78,6 +129,21 @@ func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info)
 		}
 	}
 
+	// render with the capabilities of the target cluster, not the default ones
+	if err := setCapabilities(cfg, ch, info); err != nil {
+		return nil, err
+	}
+	setClusterVersion(values, cfg.Capabilities)
+	heads, err := renderManifestHeads(ch, values, chartutil.ReleaseOptions{Name: name, Namespace: hr.Spec.Namespace}, cfg.Capabilities)
+	if err != nil {
+		// leave it to helm, which gives more details
+		klog.Warningf("render chart for api check error: %s", err.Error())
+	}
+	if err := checkDeprecatedAPIs(hr, heads, cfg.Capabilities, info, handler); err != nil {
+		return nil, err
+	}
+	setClientOptions(options, hr, heads)
+
 	// since we set install to true, do a install first if not exist
 	histClient := action.NewHistory(cfg)
 	// big enough to contains all the history
@@ -87,7 +153,7 @@ func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info)
 		klog.Warningf("Release %q does not exist. Installing it now.\n", name)
 		// emptyValues := map[string]interface{}{}
 		// rel := createRelease(cfg, ch, name, client.Namespace, emptyValues)
-		resp, err := install(hr, info, inCluster)
+		resp, err := install(hr, cfg, ch, values, options)
 		if err != nil {
 			// if error occurred, just return. Otherwise the upgrade will stuck at not deploy found
 			klog.Warning("install before upgrade failed: ", err)
@@ -97,12 +163,19 @@ func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info)
 		return resp, nil
 	}
 
+	// helm doesn't touch the CRDs in the crds/ directory on upgrade
+	if options.CRDPolicy != "" {
+		if err := applyChartCRDs(cfg, ch); err != nil {
+			return nil, err
+		}
+	}
+
 	// run upgrade/install
 	resp, err := client.Run(name, ch, values)
 	if err != nil {
 		return nil, errors.Wrap(err, "UPGRADE FAILED")
 	}
-	action.PrintRelease(out, resp)
+	logRelease(resp)
 	klog.Infof("Release %q has been upgraded. Happy Helming!\n", name)
 
 	// Print the status like status command does
@@ -111,14 +184,24 @@ func Sync(hr *v1alpha1.HelmRequest, info *cluster.Info, inCluster *cluster.Info)
 	if err != nil {
 		klog.Warningf("print status error: %s", err.Error())
 	}
-	action.PrintRelease(out, rel)
 	if rel != nil {
+		logRelease(rel)
 		hr.Status.Notes = rel.Info.Notes
 	}
 	return resp, nil
 
 }
 
+// logRelease logs the status of a release. Unlike action.PrintRelease, the notes are not printed, they
+// may be rendered from sensitive values
+func logRelease(rel *release.Release) {
+	if rel == nil || rel.Info == nil {
+		return
+	}
+	klog.Infof("release: %s, namespace: %s, revision: %d, status: %s, last deployed: %s", rel.Name,
+		rel.Namespace, rel.Version, rel.Info.Status, rel.Info.LastDeployed.Format(time.ANSIC))
+}
+
 // isHaveDeployedRelease will check the history data to find out is there a successfully deployed release
 func isHaveDeployedRelease(hist []*release.Release) bool {
 	for _, item := range hist {
@@ -0,0 +1,91 @@
+package helm
+
+import (
+	"os"
+
+	"github.com/alauda/captain/pkg/cluster"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chart/loader"
+	"helm.sh/helm/pkg/chartutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// ValidateValues checks the values of a HelmRequest against the values.schema.json files shipped in
+// it's chart. The chart is only loaded from the local repo cache, it's never downloaded during the
+// admission. If the chart is not cached or cannot be loaded, the check is skipped, this will be reported
+// by the sync process later. valuesFrom sources which cannot be resolved are also skipped.
+func ValidateValues(hr *v1alpha1.HelmRequest, cfg *rest.Config) error {
+	ch, err := loadChartForValidation(hr)
+	if os.IsNotExist(err) {
+		klog.Warningf("chart %s %s is not cached, skip values validation", hr.Spec.Chart, hr.Spec.Version)
+		return nil
+	}
+	if err != nil {
+		klog.Warningf("load chart %s for values validation error, skip it: %s", hr.Spec.Chart, err.Error())
+		return nil
+	}
+
+	if !hasSchema(ch) {
+		klog.V(4).Infof("chart %s has no values schema, skip validation", hr.Spec.Chart)
+		return nil
+	}
+
+	values, err := getValuesFromSource(hr, nil, cfg, true, nil)
+	if err != nil {
+		klog.Warningf("get values from source for %s error, skip it: %s", hr.GetName(), err.Error())
+		values = Values{}
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	new, _, err := decryptValues(Values(hr.Spec.HelmValues.DeepCopy().Values), client)
+	if err != nil {
+		klog.Warningf("decrypt values for %s error, skip validation: %s", hr.GetName(), err.Error())
+		return nil
+	}
+	values = mergeValues(values, new)
+	popExtendedSources(values)
+	// the per-cluster values depend on the target cluster, which is not known here
+	if _, err := popClusterValues(values); err != nil {
+		return err
+	}
+	// the metadata of the target cluster is injected when syncing, so the schema check should see it too
+	values = setClusterInfo(values, &cluster.Info{})
+
+	if err := chartutil.ProcessDependencies(ch, values); err != nil {
+		return err
+	}
+
+	options := chartutil.ReleaseOptions{
+		Name:      getReleaseName(hr),
+		Namespace: hr.Spec.Namespace,
+	}
+	_, err = chartutil.ToRenderValues(ch, values, options, nil)
+	return err
+}
+
+// loadChartForValidation loads the chart of a HelmRequest from the local cache
+func loadChartForValidation(hr *v1alpha1.HelmRequest) (*chart.Chart, error) {
+	cp, err := cachedChart(hr.Spec.Chart, hr.Spec.Version)
+	if err != nil {
+		return nil, err
+	}
+	return loader.Load(cp)
+}
+
+// hasSchema check if the chart or any of it's dependencies has a values schema
+func hasSchema(ch *chart.Chart) bool {
+	if ch.Schema != nil {
+		return true
+	}
+	for _, dep := range ch.Dependencies() {
+		if hasSchema(dep) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/chart"
+	"helm.sh/helm/pkg/chartutil"
+	"helm.sh/helm/pkg/helmpath"
+	"helm.sh/helm/pkg/helmpath/xdg"
+	"helm.sh/helm/pkg/repo"
+	"k8s.io/client-go/rest"
+)
+
+func TestValidateValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captain-helm-validate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer os.Setenv(xdg.CacheHomeEnvVar, os.Getenv(xdg.CacheHomeEnvVar))
+	os.Setenv(xdg.CacheHomeEnvVar, dir)
+
+	// nginx is cached and ships a schema, redis is never downloaded
+	index := repo.NewIndexFile()
+	index.Add(&chart.Metadata{Name: "nginx", Version: "1.0.0"}, "nginx-1.0.0.tgz", "https://example.com/charts", "sha")
+	index.Add(&chart.Metadata{Name: "redis", Version: "1.0.0"}, "redis-1.0.0.tgz", "https://example.com/charts", "sha")
+	assert.Nil(t, os.MkdirAll(helmpath.CachePath("repository"), 0755))
+	assert.Nil(t, index.WriteFile(helmpath.CachePath("repository", "stable-index.yaml"), 0644))
+
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV1, Name: "nginx", Version: "1.0.0"},
+		Values:   map[string]interface{}{"replicas": 1},
+		// Save doesn't write the Schema, so it's saved as a file and loaded as the schema
+		Files: []*chart.File{{Name: "values.schema.json", Data: []byte(`{
+  "type": "object",
+  "properties": {
+    "replicas": {"type": "integer", "minimum": 1}
+  }
+}`)}},
+	}
+	p, err := chartutil.Save(ch, dir)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Rename(p, helmpath.CachePath("repository", filepath.Base(p))))
+
+	newHelmRequest := func(chart string, values chartutil.Values) *v1alpha1.HelmRequest {
+		hr := &v1alpha1.HelmRequest{}
+		hr.Name = "nginx"
+		hr.Namespace = "default"
+		hr.Spec.Chart = chart
+		hr.Spec.Namespace = "default"
+		hr.Spec.HelmValues.Values = values
+		return hr
+	}
+	// no api calls without valuesFrom and encrypted values
+	cfg := &rest.Config{Host: "http://127.0.0.1:1"}
+
+	assert.Nil(t, ValidateValues(newHelmRequest("stable/nginx", chartutil.Values{"replicas": 3}), cfg))
+	assert.Nil(t, ValidateValues(newHelmRequest("stable/nginx", nil), cfg))
+	assert.NotNil(t, ValidateValues(newHelmRequest("stable/nginx", chartutil.Values{"replicas": "three"}), cfg))
+	assert.NotNil(t, ValidateValues(newHelmRequest("stable/nginx", chartutil.Values{"replicas": 0}), cfg))
+
+	// not cached, skipped
+	assert.Nil(t, ValidateValues(newHelmRequest("stable/redis", chartutil.Values{"replicas": "three"}), cfg))
+}
@@ -2,9 +2,11 @@ package helm
 
 import (
 	"fmt"
+	"sort"
 
 	"k8s.io/klog"
 
+	"github.com/alauda/captain/pkg/cluster"
 	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
 	"github.com/ghodss/yaml"
 	"helm.sh/helm/pkg/chartutil"
@@ -46,22 +48,65 @@ func mergeValues(dest, src Values) Values {
 	return dest
 }
 
-// getValues merges all values settings from spec/configmap/secret...
-func getValues(hr *v1alpha1.HelmRequest, cfg *rest.Config) (chartutil.Values, error) {
-	values, err := getValuesFromSource(hr, cfg)
+// getValues merges all values settings from spec/configmap/secret..., then the per-cluster values
+// for the target cluster. The metadata of the target cluster is also injected. The sensitive values are
+// collected by the redactor.
+func getValues(hr *v1alpha1.HelmRequest, info *cluster.Info, cfg *rest.Config, r *redactor) (chartutil.Values, error) {
+	values, err := getValuesFromSource(hr, info, cfg, false, r)
 	if err != nil {
 		return nil, err
 	}
 
-	new := Values(hr.Spec.HelmValues.DeepCopy().Values)
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	inline := Values(hr.Spec.HelmValues.DeepCopy().Values)
+	new, decrypted, err := decryptValues(inline, client)
+	if err != nil {
+		return nil, err
+	}
+	if decrypted {
+		r.addDecrypted(inline, new)
+	}
 	values = mergeValues(values, new)
-	klog.Infof("get values for helm request: %s  %+v", hr.GetName(), values)
+	popExtendedSources(values)
+
+	clusterValues, err := popClusterValues(values)
+	if err != nil {
+		return nil, err
+	}
+	if clusterValues != nil {
+		v, err := getClusterValues(clusterValues, info)
+		if err != nil {
+			return nil, err
+		}
+		values = mergeValues(values, v)
+	}
+	values = setClusterInfo(values, info)
+	r.addValues(values)
+	// values may contain decrypted data, only log the keys
+	klog.Infof("get values for helm request: %s, keys: %v", hr.GetName(), valuesKeys(values))
+	klog.V(4).Infof("values for helm request %s: %v", hr.GetName(), r.redactValues(values))
 	return values, nil
 
 }
 
-func getValuesFromSource(hr *v1alpha1.HelmRequest, cfg *rest.Config) (chartutil.Values, error) {
-	klog.V(2).Infof("in cluster rest config is: %+v", cfg)
+// valuesKeys returns the top level keys of values, sorted
+func valuesKeys(values Values) []string {
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getValuesFromSource merges values from all the valuesFrom sources, then the extended sources in
+// captain.valuesFrom. If skipErrors is true, the sources which cannot be resolved will be skipped, and
+// so are the url sources. info is the target cluster, nil if unknown. Values from secrets are marked as
+// sensitive in the redactor, which can be nil.
+func getValuesFromSource(hr *v1alpha1.HelmRequest, info *cluster.Info, cfg *rest.Config, skipErrors bool, r *redactor) (chartutil.Values, error) {
 	client, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, err
@@ -73,8 +118,12 @@ func getValuesFromSource(hr *v1alpha1.HelmRequest, cfg *rest.Config) (chartutil.
 	if hr.Spec.ValuesFrom != nil {
 		for _, s := range hr.Spec.ValuesFrom {
 			if s.ConfigMapKeyRef != nil {
-				v, err := getValuesFromConfigMap(s.ConfigMapKeyRef, client, ns)
+				v, err := getValuesFromConfigMap(s.ConfigMapKeyRef, client, ns, r)
 				if err != nil {
+					if skipErrors {
+						klog.Warningf("skip values from configmap %s: %s", s.ConfigMapKeyRef.Name, err.Error())
+						continue
+					}
 					return nil, err
 				}
 				values = mergeValues(values, v)
@@ -82,19 +131,29 @@ func getValuesFromSource(hr *v1alpha1.HelmRequest, cfg *rest.Config) (chartutil.
 			}
 
 			if s.SecretKeyRef != nil {
-				v, err := getValuesFromSecret(s.SecretKeyRef, client, ns)
+				v, err := getValuesFromSecret(s.SecretKeyRef, client, ns, r)
 				if err != nil {
+					if skipErrors {
+						klog.Warningf("skip values from secret %s: %s", s.SecretKeyRef.Name, err.Error())
+						continue
+					}
 					return nil, err
 				}
 				values = mergeValues(values, v)
 			}
 		}
 	}
-	return values, nil
+
+	ctx := &sourceContext{hr: hr, cfg: cfg, info: info, skipURL: skipErrors, redactor: r}
+	v, err := getValuesFromExtendedSources(ctx, skipErrors)
+	if err != nil {
+		return nil, err
+	}
+	return mergeValues(values, v), nil
 
 }
 
-func getValuesFromSecret(s *v1.SecretKeySelector, client *kubernetes.Clientset, ns string) (chartutil.Values, error) {
+func getValuesFromSecret(s *v1.SecretKeySelector, client *kubernetes.Clientset, ns string, r *redactor) (chartutil.Values, error) {
 	optional := s.Optional != nil && *s.Optional
 	secret, err := client.CoreV1().Secrets(ns).Get(s.Name, metav1.GetOptions{})
 	if err != nil {
@@ -125,10 +184,18 @@ func getValuesFromSecret(s *v1.SecretKeySelector, client *kubernetes.Clientset,
 		}
 		return nil, err
 	}
-	return values, nil
+	result, decrypted, err := decryptDocument(data, values, client)
+	if err != nil {
+		return nil, err
+	}
+	if decrypted {
+		r.addDecrypted(values, result)
+	}
+	r.addSecret(result)
+	return result, nil
 }
 
-func getValuesFromConfigMap(c *v1.ConfigMapKeySelector, client *kubernetes.Clientset, ns string) (chartutil.Values, error) {
+func getValuesFromConfigMap(c *v1.ConfigMapKeySelector, client *kubernetes.Clientset, ns string, r *redactor) (chartutil.Values, error) {
 	optional := c.Optional != nil && *c.Optional
 	cm, err := client.CoreV1().ConfigMaps(ns).Get(c.Name, metav1.GetOptions{})
 	if err != nil {
@@ -159,5 +226,12 @@ func getValuesFromConfigMap(c *v1.ConfigMapKeySelector, client *kubernetes.Clien
 		}
 		return nil, err
 	}
-	return values, nil
+	result, decrypted, err := decryptDocument([]byte(data), values, client)
+	if err != nil {
+		return nil, err
+	}
+	if decrypted {
+		r.addDecrypted(values, result)
+	}
+	return result, nil
 }
@@ -0,0 +1,70 @@
+package helmrequest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ParseDependency parse a dependency of HelmRequest, which can be <name> or <namespace>/<name>.
+// namespace is the namespace of the HelmRequest, used for the first format.
+func ParseDependency(dep, namespace string) (string, string) {
+	ss := strings.SplitN(dep, "/", 2)
+	if len(ss) == 1 {
+		return namespace, dep
+	}
+	return ss[0], ss[1]
+}
+
+// Getter get a HelmRequest by namespace and name
+type Getter func(namespace, name string) (*v1alpha1.HelmRequest, error)
+
+// FindCycle walks the dependency graph from a HelmRequest, and returns the first cycle found as a list
+// of <namespace>/<name>, the first and last items are the same. Returns nil if there is no cycle.
+// Dependencies not found are ignored.
+func FindCycle(hr *v1alpha1.HelmRequest, get Getter) ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var path []string
+
+	var visit func(hr *v1alpha1.HelmRequest) ([]string, error)
+	visit = func(hr *v1alpha1.HelmRequest) ([]string, error) {
+		key := fmt.Sprintf("%s/%s", hr.GetNamespace(), hr.GetName())
+		switch state[key] {
+		case visited:
+			return nil, nil
+		case visiting:
+			for i, item := range path {
+				if item == key {
+					return append(append([]string{}, path[i:]...), key), nil
+				}
+			}
+		}
+
+		state[key] = visiting
+		path = append(path, key)
+		for _, dep := range hr.Spec.Dependencies {
+			ns, name := ParseDependency(dep, hr.GetNamespace())
+			d, err := get(ns, name)
+			if err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			if cycle, err := visit(d); cycle != nil || err != nil {
+				return cycle, err
+			}
+		}
+		path = path[:len(path)-1]
+		state[key] = visited
+		return nil, nil
+	}
+
+	return visit(hr)
+}
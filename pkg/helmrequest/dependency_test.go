@@ -0,0 +1,66 @@
+package helmrequest
+
+import (
+	"testing"
+
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newHelmRequest(namespace, name string, deps ...string) *v1alpha1.HelmRequest {
+	return &v1alpha1.HelmRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       v1alpha1.HelmRequestSpec{Dependencies: deps},
+	}
+}
+
+func newGetter(items ...*v1alpha1.HelmRequest) Getter {
+	return func(namespace, name string) (*v1alpha1.HelmRequest, error) {
+		for _, item := range items {
+			if item.Namespace == namespace && item.Name == name {
+				return item, nil
+			}
+		}
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "helmrequests"}, name)
+	}
+}
+
+func TestParseDependency(t *testing.T) {
+	ns, name := ParseDependency("a", "default")
+	assert.Equal(t, "default", ns)
+	assert.Equal(t, "a", name)
+
+	ns, name = ParseDependency("kube-system/a", "default")
+	assert.Equal(t, "kube-system", ns)
+	assert.Equal(t, "a", name)
+}
+
+func TestFindCycle(t *testing.T) {
+	t.Run("no cycle", func(t *testing.T) {
+		a := newHelmRequest("default", "a", "b", "other/c")
+		b := newHelmRequest("default", "b", "other/c")
+		c := newHelmRequest("other", "c", "missing")
+		cycle, err := FindCycle(a, newGetter(a, b, c))
+		assert.Nil(t, err)
+		assert.Nil(t, cycle)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		a := newHelmRequest("default", "a", "b")
+		b := newHelmRequest("default", "b", "other/c")
+		c := newHelmRequest("other", "c", "default/b")
+		cycle, err := FindCycle(a, newGetter(a, b, c))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"default/b", "other/c", "default/b"}, cycle)
+	})
+
+	t.Run("self", func(t *testing.T) {
+		a := newHelmRequest("default", "a", "a")
+		cycle, err := FindCycle(a, newGetter(a))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"default/a", "default/a"}, cycle)
+	})
+}
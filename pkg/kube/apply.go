@@ -0,0 +1,198 @@
+package kube
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/pkg/kube"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/klog"
+)
+
+// ConflictPolicy decides what to do if a field is changed by both the chart and others
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite means the value of the chart is applied, the conflicts are only recorded. This is the default
+	ConflictOverwrite ConflictPolicy = "Overwrite"
+
+	// ConflictFail means the resources with conflicts are not updated, and the action fails
+	ConflictFail ConflictPolicy = "Fail"
+)
+
+// Update creates the resources not exist, and patches the existing ones with a three-way merge: only the
+// changes between the original and the target manifests are applied to the live objects, so the fields set
+// by others, like the replicas managed by a HPA or the injected sidecars, are kept. The resources in
+// original but not in target are deleted.
+// If force is true, a resource failed to patch is deleted and recreated.
+// The CRDs are handled by the CRD policy first.
+func (c *Client) Update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	if c.options.CRDPolicy == "" {
+		return c.update(original, target, force)
+	}
+
+	crds, others := splitCRDs(target)
+	originalCRDs, originalOthers := splitCRDs(original)
+	res := &kube.Result{}
+	if err := c.applyCRDs(crds, originalCRDs, res); err != nil {
+		return nil, err
+	}
+	result, err := c.update(originalOthers, others, force)
+	if err != nil {
+		return nil, err
+	}
+	c.deleteCRDs(originalCRDs.Difference(crds), res)
+	return mergeResults(res, result), nil
+}
+
+// Delete deletes the resources, the CRDs are kept if the CRD policy is Skip or Never-delete
+func (c *Client) Delete(resources kube.ResourceList) (*kube.Result, []error) {
+	if c.options.CRDPolicy == CRDSkip || c.options.CRDPolicy == CRDNeverDelete {
+		crds, others := splitCRDs(resources)
+		for _, info := range crds {
+			klog.Infof("keep CRD %s by the CRD policy %s", info.Name, c.options.CRDPolicy)
+		}
+		if len(others) == 0 {
+			return &kube.Result{}, nil
+		}
+		resources = others
+	}
+	return c.Client.Delete(resources)
+}
+
+func (c *Client) update(original, target kube.ResourceList, force bool) (*kube.Result, error) {
+	var updateErrors []string
+	res := &kube.Result{}
+
+	c.Log("checking %d resources for changes", len(target))
+	err := target.Visit(func(info *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+
+		helper := resource.NewHelper(info.Client, info.Mapping)
+		current, err := helper.Get(info.Namespace, info.Name, info.Export)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return errors.Wrap(err, "could not get information about the resource")
+			}
+			if err := createResource(info); err != nil {
+				return errors.Wrap(err, "failed to create resource")
+			}
+			res.Created = append(res.Created, info)
+			c.Log("Created a new %s called %q\n", info.Mapping.GroupVersionKind.Kind, info.Name)
+			return nil
+		}
+
+		// the resources not in the previous release are adopted
+		var originalObj runtime.Object
+		if originalInfo := original.Get(info); originalInfo != nil {
+			originalObj = originalInfo.Object
+		}
+		if err := c.updateResource(info, originalObj, current, force); err != nil {
+			c.Log("error updating the resource %q:\n\t %v", info.Name, err)
+			updateErrors = append(updateErrors, err.Error())
+		}
+		// Because we check for errors later, append the info regardless
+		res.Updated = append(res.Updated, info)
+		return nil
+	})
+
+	switch {
+	case err != nil:
+		return nil, err
+	case len(updateErrors) != 0:
+		return nil, errors.Errorf(strings.Join(updateErrors, " && "))
+	}
+
+	return c.deleteRemoved(original, target, res), nil
+}
+
+// deleteRemoved deletes the resources in original but not in target
+func (c *Client) deleteRemoved(original, target kube.ResourceList, res *kube.Result) *kube.Result {
+	for _, info := range original.Difference(target) {
+		c.Log("Deleting %q in %s...", info.Name, info.Namespace)
+		if err := deleteResource(info); err != nil {
+			c.Log("Failed to delete %q, err: %s", info.Name, err)
+		} else {
+			res.Deleted = append(res.Deleted, info)
+		}
+	}
+	return res
+}
+
+func mergeResults(a, b *kube.Result) *kube.Result {
+	return &kube.Result{
+		Created: append(a.Created, b.Created...),
+		Updated: append(a.Updated, b.Updated...),
+		Deleted: append(a.Deleted, b.Deleted...),
+	}
+}
+
+// updateResource patches a resource, the conflicts are recorded or fail the update by the conflict policy
+func (c *Client) updateResource(target *resource.Info, original, current runtime.Object, force bool) error {
+	kind := target.Mapping.GroupVersionKind.Kind
+	patch, patchType, fields, err := createThreeWayPatch(target, original, current)
+	if err != nil {
+		return errors.Wrap(err, "failed to create patch")
+	}
+
+	if len(fields) > 0 {
+		var conflicts []string
+		for _, f := range fields {
+			conflicts = append(conflicts, kind+"/"+target.Name+": "+f)
+		}
+		c.options.conflict(conflicts...)
+		if c.options.ConflictPolicy == ConflictFail {
+			return errors.Errorf("fields changed by others: %s", strings.Join(conflicts, ", "))
+		}
+		klog.Warningf("overwrite the fields changed by others: %s", strings.Join(conflicts, ", "))
+	}
+
+	if patch == nil {
+		c.Log("Looks like there are no changes for %s %q", kind, target.Name)
+		// make sure the info is the latest, the labels are used by other functions
+		if err := target.Get(); err != nil {
+			return errors.Wrap(err, "error trying to refresh resource information")
+		}
+		return nil
+	}
+
+	helper := resource.NewHelper(target.Client, target.Mapping)
+	c.Log("Preparing patch for %s %q", kind, target.Name)
+	obj, err := helper.Patch(target.Namespace, target.Name, patchType, patch, nil)
+	if err == nil {
+		return target.Refresh(obj, true)
+	}
+	klog.Warningf("cannot patch %s %q: %s", kind, target.Name, err.Error())
+	if !force {
+		return err
+	}
+
+	if err := deleteResource(target); err != nil {
+		return err
+	}
+	if err := createResource(target); err != nil {
+		return errors.Wrap(err, "failed to recreate resource")
+	}
+	klog.Infof("recreated %s %q", kind, target.Name)
+	return nil
+}
+
+func createResource(info *resource.Info) error {
+	obj, err := resource.NewHelper(info.Client, info.Mapping).Create(info.Namespace, true, info.Object, nil)
+	if err != nil {
+		return err
+	}
+	return info.Refresh(obj, true)
+}
+
+func deleteResource(info *resource.Info) error {
+	policy := metav1.DeletePropagationBackground
+	opts := &metav1.DeleteOptions{PropagationPolicy: &policy}
+	_, err := resource.NewHelper(info.Client, info.Mapping).DeleteWithOptions(info.Namespace, info.Name, opts)
+	return err
+}
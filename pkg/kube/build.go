@@ -0,0 +1,95 @@
+package kube
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"helm.sh/helm/pkg/kube"
+	"helm.sh/helm/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// BuildPolicy decides what to do if some resources of a manifest can not be built, mostly because their
+// kinds are unknown to the cluster
+type BuildPolicy string
+
+const (
+	// BuildStrict means any build error fails the action. This is the default
+	BuildStrict BuildPolicy = "Strict"
+
+	// BuildSkipPendingCRDs means the custom resources whose CRDs are declared in the same chart are skipped
+	// if the CRDs are not served yet, other errors still fail the action
+	BuildSkipPendingCRDs BuildPolicy = "SkipPendingCRDs"
+
+	// BuildIgnoreErrors means the resources failed to build are ignored. It's only used for uninstall, a
+	// release should be able to be deleted even if some of it's APIs are gone
+	BuildIgnoreErrors BuildPolicy = "IgnoreErrors"
+)
+
+// buildHead is the part of a manifest to find out it's kind
+type buildHead struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+}
+
+// splitManifest splits a multi-document manifest, the order of the documents is kept
+func splitManifest(manifest string) []string {
+	docs := releaseutil.SplitManifests(manifest)
+	result := make([]string, 0, len(docs))
+	for i := 0; i < len(docs); i++ {
+		result = append(result, docs[fmt.Sprintf("manifest-%d", i)])
+	}
+	return result
+}
+
+// isNoMatchError checks if the error is caused by a kind unknown to the cluster. The builder wraps the
+// error of the rest mapper as a string, so the message is also checked.
+func isNoMatchError(err error) bool {
+	if meta.IsNoMatchError(err) {
+		return true
+	}
+	return strings.Contains(err.Error(), "no matches for kind")
+}
+
+// pendingCRDObject returns <Kind>/<name> of the document if it's a custom resource of the CRDs declared
+// in the chart, or an empty string if not
+func (o *Options) pendingCRDObject(doc string) string {
+	var head buildHead
+	if err := yaml.Unmarshal([]byte(doc), &head); err != nil {
+		return ""
+	}
+	group := path.Dir(head.APIVersion)
+	if group == "." {
+		group = ""
+	}
+	if !o.CRDKinds[path.Join(group, head.Kind)] {
+		return ""
+	}
+	return head.Kind + "/" + head.Metadata.Name
+}
+
+// buildSkipPendingCRDs builds the documents of the manifest one by one, the custom resources whose CRDs
+// are in the chart but not served yet are skipped and recorded
+func (c *Client) buildSkipPendingCRDs(manifest string) (kube.ResourceList, error) {
+	var result kube.ResourceList
+	for _, doc := range splitManifest(manifest) {
+		infos, err := c.Client.Build(strings.NewReader(doc))
+		if err != nil {
+			object := c.options.pendingCRDObject(doc)
+			if object == "" || !isNoMatchError(err) {
+				return nil, err
+			}
+			klog.Warningf("skip %s, it's CRD is not served yet: %s", object, err.Error())
+			c.options.skip(object)
+			continue
+		}
+		result = append(result, infos...)
+	}
+	return result, nil
+}
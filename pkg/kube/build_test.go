@@ -0,0 +1,55 @@
+package kube
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"helm.sh/helm/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+const testManifest = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: demo
+---
+apiVersion: stable.example.com/v1
+kind: CronTab
+metadata:
+  name: demo
+`
+
+func TestSplitManifest(t *testing.T) {
+	docs := splitManifest(testManifest)
+	assert.Equal(t, 2, len(docs))
+	assert.Equal(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: demo", docs[0])
+}
+
+func TestPendingCRDObject(t *testing.T) {
+	options := &Options{CRDKinds: map[string]bool{"stable.example.com/CronTab": true}}
+	docs := splitManifest(testManifest)
+	assert.Equal(t, "", options.pendingCRDObject(docs[0]))
+	assert.Equal(t, "CronTab/demo", options.pendingCRDObject(docs[1]))
+}
+
+func TestIsNoMatchError(t *testing.T) {
+	err := &meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: "stable.example.com", Kind: "CronTab"}}
+	assert.True(t, isNoMatchError(err))
+	assert.True(t, isNoMatchError(errors.New(`unable to recognize "": `+err.Error())))
+	assert.False(t, isNoMatchError(errors.New("error validating data")))
+}
+
+func TestSplitCRDs(t *testing.T) {
+	crd := &resource.Info{Name: "crontabs.stable.example.com", Mapping: &meta.RESTMapping{
+		GroupVersionKind: schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1beta1", Kind: "CustomResourceDefinition"},
+	}}
+	cr := &resource.Info{Name: "demo", Mapping: &meta.RESTMapping{
+		GroupVersionKind: schema.GroupVersionKind{Group: "stable.example.com", Version: "v1", Kind: "CronTab"},
+	}}
+	crds, others := splitCRDs(kube.ResourceList{cr, crd})
+	assert.Equal(t, kube.ResourceList{crd}, crds)
+	assert.Equal(t, kube.ResourceList{cr}, others)
+}
@@ -1,7 +1,10 @@
 package kube
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
+
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/client-go/kubernetes/scheme"
 
@@ -20,44 +23,82 @@ func init() {
 //Client is a thin wrapper around helm.kube
 type Client struct {
 	*kube.Client
+
+	getter  genericclioptions.RESTClientGetter
+	options *Options
 }
 
-// New creates a new Client.
-func New(getter genericclioptions.RESTClientGetter) *Client {
+// New creates a new Client. If options is nil, the default policies are used
+func New(getter genericclioptions.RESTClientGetter, options *Options) *Client {
 
 	client := kube.New(getter)
 	client.Factory = newFactory(client.Factory)
 
+	if options == nil {
+		options = &Options{BuildPolicy: BuildStrict, ConflictPolicy: ConflictOverwrite}
+	}
+
 	return &Client{
 		client,
+		getter,
+		options,
 	}
 }
 
-
 // Build validates for Kubernetes objects and returns resource Infos from a io.Reader.
+// The errors are handled by the build policy of the client.
 func (c *Client) Build(reader io.Reader) (kube.ResourceList, error) {
-	result, err := c.Client.Build(reader)
+	data, err := ioutil.ReadAll(reader)
 	if err != nil {
-		klog.Warning("build resources error: ", err)
+		return nil, err
+	}
+	result, err := c.Client.Build(bytes.NewReader(data))
+	if err == nil {
+		return result, nil
+	}
 
-		// if strings.Contains(err.Error(), "apiVersion") && strings.Contains(err.Error(), "is not available") {
-		//	klog.Warning("encountered apiVersion not found it, ignore it: ", err)
-		//	return result, nil
-		// }
+	switch c.options.BuildPolicy {
+	case BuildIgnoreErrors:
+		klog.Warning("build resources error, ignore it: ", err)
+		return result, nil
+	case BuildSkipPendingCRDs:
+		return c.buildSkipPendingCRDs(string(data))
+	default:
+		return nil, err
 	}
-	return result, nil
 }
 
 
-// Create plan to support replace.... hold on...
+// Create creates the resources. If some of them already exist, like when a failed install is retried,
+// they are updated with the fields in the manifest, the other fields of the live objects are kept.
+// The CRDs are handled by the CRD policy first.
 func (c *Client) Create(resources kube.ResourceList) (*kube.Result, error) {
+	if c.options.CRDPolicy == "" {
+		return c.create(resources)
+	}
+
+	crds, others := splitCRDs(resources)
+	res := &kube.Result{}
+	if err := c.applyCRDs(crds, nil, res); err != nil {
+		return nil, err
+	}
+	if len(others) == 0 {
+		return res, nil
+	}
+	result, err := c.create(others)
+	if err != nil {
+		return result, err
+	}
+	return mergeResults(res, result), nil
+}
 
+func (c *Client) create(resources kube.ResourceList) (*kube.Result, error) {
 	result, err := c.Client.Create(resources)
 	if err != nil {
 		klog.Warning("create resource error:", err)
 		if errors.IsAlreadyExists(err) {
-			klog.Warningf("create error due to resource exist, do a dumb update...")
-			return c.Client.Update(resources, resources, true)
+			klog.Warningf("create error due to resource exist, apply the manifest to them...")
+			return c.Update(nil, resources, true)
 		}
 		return result, err
 	}
@@ -0,0 +1,160 @@
+package kube
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/pkg/kube"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	clientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// CRDPolicy decides how the CRDs of a chart are installed, upgraded and deleted, both the ones in the crds/
+// directory and the ones in the templates
+type CRDPolicy string
+
+const (
+	// CRDSkip means the CRDs are never created, updated or deleted, they should be installed by others
+	CRDSkip CRDPolicy = "Skip"
+
+	// CRDCreate means the CRDs are created if they don't exist, but never updated
+	CRDCreate CRDPolicy = "Create"
+
+	// CRDCreateReplace means the CRDs are created or updated on every install and upgrade
+	CRDCreateReplace CRDPolicy = "CreateReplace"
+
+	// CRDNeverDelete is the same as CreateReplace, but the CRDs are never deleted, even if the release
+	// is uninstalled or they are removed from the chart
+	CRDNeverDelete CRDPolicy = "Never-delete"
+)
+
+// CRDEstablishTimeout is how long to wait for the CRDs to be established
+var CRDEstablishTimeout = 60 * time.Second
+
+// isCRD checks if the resource is a CRD
+func isCRD(info *resource.Info) bool {
+	gk := info.Mapping.GroupVersionKind.GroupKind()
+	return gk.Group == "apiextensions.k8s.io" && gk.Kind == "CustomResourceDefinition"
+}
+
+// splitCRDs splits the CRDs out of the resources
+func splitCRDs(resources kube.ResourceList) (crds, others kube.ResourceList) {
+	for _, info := range resources {
+		if isCRD(info) {
+			crds = append(crds, info)
+		} else {
+			others = append(others, info)
+		}
+	}
+	return crds, others
+}
+
+// applyCRDs creates or updates the CRDs by the CRD policy, then waits until they are established, so the
+// custom resources applied after them can be found
+func (c *Client) applyCRDs(crds, original kube.ResourceList, res *kube.Result) error {
+	if len(crds) == 0 {
+		return nil
+	}
+	if c.options.CRDPolicy == CRDSkip {
+		for _, info := range crds {
+			klog.Infof("skip CRD %s by the CRD policy %s", info.Name, c.options.CRDPolicy)
+		}
+		return nil
+	}
+
+	var names []string
+	for _, info := range crds {
+		names = append(names, info.Name)
+		current, err := resource.NewHelper(info.Client, info.Mapping).Get(info.Namespace, info.Name, info.Export)
+		switch {
+		case apierrors.IsNotFound(err):
+			if err := createResource(info); err != nil {
+				return errors.Wrapf(err, "failed to create CRD %s", info.Name)
+			}
+			res.Created = append(res.Created, info)
+		case err != nil:
+			return errors.Wrapf(err, "could not get CRD %s", info.Name)
+		case c.options.CRDPolicy == CRDCreate:
+			c.Log("CRD %s is already present. Skipping.", info.Name)
+		default:
+			var originalObj runtime.Object
+			if originalInfo := original.Get(info); originalInfo != nil {
+				originalObj = originalInfo.Object
+			}
+			if err := c.updateResource(info, originalObj, current, false); err != nil {
+				return errors.Wrapf(err, "failed to update CRD %s", info.Name)
+			}
+			res.Updated = append(res.Updated, info)
+		}
+	}
+
+	cfg, err := c.getter.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+	if err := WaitForCRDs(cfg, names, CRDEstablishTimeout); err != nil {
+		return err
+	}
+	// the discovery cache does not have the new CRDs
+	dc, err := c.getter.ToDiscoveryClient()
+	if err != nil {
+		return err
+	}
+	dc.Invalidate()
+	return nil
+}
+
+// deleteCRDs deletes the CRDs removed from the chart, unless the CRD policy keeps them
+func (c *Client) deleteCRDs(crds kube.ResourceList, res *kube.Result) {
+	if c.options.CRDPolicy == CRDSkip || c.options.CRDPolicy == CRDNeverDelete {
+		for _, info := range crds {
+			klog.Infof("keep CRD %s removed from the chart by the CRD policy %s", info.Name, c.options.CRDPolicy)
+		}
+		return
+	}
+	c.deleteRemoved(crds, nil, res)
+}
+
+// IsCRDEstablished checks if the CRD is established, which means it's custom resources are served
+func IsCRDEstablished(crd *v1beta1.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == v1beta1.Established {
+			return cond.Status == v1beta1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// WaitForCRDs waits until all the CRDs are established or the timeout
+func WaitForCRDs(cfg *rest.Config, names []string, timeout time.Duration) error {
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	var pending string
+	err = wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		for _, name := range names {
+			crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(name, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return false, err
+			}
+			if err != nil || !IsCRDEstablished(crd) {
+				pending = name
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out waiting for CRD %s to be established", pending)
+	}
+	return err
+}
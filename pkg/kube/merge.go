@@ -0,0 +1,203 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// createThreeWayPatch creates a patch like kubectl apply: the fields removed between the original and the target
+// manifests are deleted, and the fields of the target which differ from the live object are set, so the fields
+// of the chart changed out-of-band are restored. The fields not in the chart, like the ones added by others,
+// are left as they are. If original is nil, nothing is deleted.
+// The fields changed by both the chart and others are returned as conflicts.
+func createThreeWayPatch(target *resource.Info, original, current runtime.Object) ([]byte, types.PatchType, []string, error) {
+	originalData := []byte("{}")
+	if original != nil {
+		data, err := json.Marshal(original)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "serializing original configuration")
+		}
+		originalData = data
+	}
+	modifiedData, err := json.Marshal(target.Object)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "serializing target configuration")
+	}
+	currentData, err := json.Marshal(current)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "serializing current configuration")
+	}
+
+	conflicts, err := findConflicts(originalData, modifiedData, currentData)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var patch []byte
+	patchType := types.StrategicMergePatchType
+	gvk := target.Mapping.GroupVersionKind
+	versioned, err := scheme.Scheme.New(gvk)
+	// strategic merge patch is not supported by custom resources and CRDs
+	if runtime.IsNotRegisteredError(err) || gvk.Group == "apiextensions.k8s.io" {
+		patchType = types.MergePatchType
+		patch, err = jsonmergepatch.CreateThreeWayJSONMergePatch(originalData, modifiedData, currentData)
+		if err != nil {
+			return nil, patchType, nil, fmt.Errorf("failed to create three-way merge patch: %v", err)
+		}
+	} else if err != nil {
+		return nil, patchType, nil, fmt.Errorf("failed to get versioned object: %v", err)
+	} else {
+		lookup, err := strategicpatch.NewPatchMetaFromStruct(versioned)
+		if err != nil {
+			return nil, patchType, nil, fmt.Errorf("failed to get patch meta: %v", err)
+		}
+		// the conflicts are reported above, the values of the chart always win
+		patch, err = strategicpatch.CreateThreeWayMergePatch(originalData, modifiedData, currentData, lookup, true)
+		if err != nil {
+			return nil, patchType, nil, fmt.Errorf("failed to create three-way merge patch: %v", err)
+		}
+	}
+
+	if string(patch) == "{}" {
+		return nil, patchType, conflicts, nil
+	}
+	// the patch may only contain directives like $setElementOrder, skip it if the live object is not changed
+	noop, err := isNoopPatch(currentData, patch, patchType, versioned)
+	if err != nil {
+		return nil, patchType, nil, err
+	}
+	if noop {
+		return nil, patchType, conflicts, nil
+	}
+	return patch, patchType, conflicts, nil
+}
+
+// isNoopPatch checks if applying the patch to the live object changes nothing
+func isNoopPatch(current, patch []byte, patchType types.PatchType, versioned runtime.Object) (bool, error) {
+	var patched []byte
+	var err error
+	if patchType == types.StrategicMergePatchType {
+		patched, err = strategicpatch.StrategicMergePatch(current, patch, versioned)
+	} else {
+		patched, err = jsonpatch.MergePatch(current, patch)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to apply patch: %v", err)
+	}
+
+	var before, after interface{}
+	if err := json.Unmarshal(current, &before); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(before, after), nil
+}
+
+// findConflicts returns the fields changed by the chart, which are also changed to another value in the
+// cluster. The lists of named items, like containers, are matched by name.
+func findConflicts(original, modified, current []byte) ([]string, error) {
+	var o, m, c interface{}
+	for _, item := range []struct {
+		data []byte
+		obj  *interface{}
+	}{{original, &o}, {modified, &m}, {current, &c}} {
+		if err := json.Unmarshal(item.data, item.obj); err != nil {
+			return nil, err
+		}
+	}
+	return diffConflicts("", o, m, c), nil
+}
+
+func diffConflicts(path string, original, modified, current interface{}) []string {
+	// not changed by the chart, added by the chart, or removed in the cluster
+	if reflect.DeepEqual(original, modified) || original == nil || current == nil {
+		return nil
+	}
+	// not changed in the cluster, or already the same as the chart
+	if reflect.DeepEqual(original, current) || reflect.DeepEqual(modified, current) {
+		return nil
+	}
+
+	switch o := original.(type) {
+	case map[string]interface{}:
+		m, ok := modified.(map[string]interface{})
+		c, ok2 := current.(map[string]interface{})
+		if ok && ok2 {
+			var conflicts []string
+			for _, k := range unionKeys(o, m) {
+				conflicts = append(conflicts, diffConflicts(joinPath(path, k), o[k], m[k], c[k])...)
+			}
+			return conflicts
+		}
+	case []interface{}:
+		om, ok := namedItems(o)
+		mm, ok2 := namedItems(modified)
+		cm, ok3 := namedItems(current)
+		if ok && ok2 && ok3 {
+			var conflicts []string
+			for _, k := range unionKeys(om, mm) {
+				conflicts = append(conflicts, diffConflicts(fmt.Sprintf("%s[%s]", path, k), om[k], mm[k], cm[k])...)
+			}
+			return conflicts
+		}
+	}
+	return []string{path}
+}
+
+// namedItems converts a list of objects with names to a map, the second return value is false if it's not
+// such a list
+func namedItems(list interface{}) (map[string]interface{}, bool) {
+	items, ok := list.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	result := map[string]interface{}{}
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := obj["name"].(string)
+		if !ok {
+			return nil, false
+		}
+		result[name] = obj
+	}
+	return result, true
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	set := map[string]bool{}
+	for k := range a {
+		set[k] = true
+	}
+	for k := range b {
+		set[k] = true
+	}
+	var keys []string
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,119 @@
+package kube
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+func newDeployment(replicas int64, containers ...map[string]interface{}) *unstructured.Unstructured {
+	var items []interface{}
+	for _, c := range containers {
+		items = append(items, c)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "demo"},
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"containers": items},
+			},
+		},
+	}}
+}
+
+func container(name, image string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "image": image}
+}
+
+// patchContainers returns the containers in a deployment patch
+func patchContainers(t *testing.T, patch []byte) []interface{} {
+	var p map[string]interface{}
+	assert.Nil(t, json.Unmarshal(patch, &p))
+	spec := p["spec"].(map[string]interface{})
+	return spec["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+}
+
+func TestCreateThreeWayPatch(t *testing.T) {
+	mapping := &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}}
+	original := newDeployment(1, container("app", "app:v1"))
+	// a sidecar injected
+	current := newDeployment(1, container("app", "app:v1"), container("proxy", "proxy:v1"))
+
+	target := &resource.Info{Mapping: mapping, Object: newDeployment(1, container("app", "app:v2"))}
+	patch, patchType, conflicts, err := createThreeWayPatch(target, original, current)
+	assert.Nil(t, err)
+	assert.Equal(t, types.StrategicMergePatchType, patchType)
+	assert.Equal(t, 0, len(conflicts))
+
+	var p map[string]interface{}
+	assert.Nil(t, json.Unmarshal(patch, &p))
+	_, ok := p["spec"].(map[string]interface{})["replicas"]
+	assert.False(t, ok)
+	// the sidecar is not deleted
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "app", "image": "app:v2"}}, patchContainers(t, patch))
+
+	// no changes in the chart
+	target.Object = newDeployment(1, container("app", "app:v1"))
+	patch, _, _, err = createThreeWayPatch(target, original, current)
+	assert.Nil(t, err)
+	assert.Nil(t, patch)
+
+	// changed by both
+	current = newDeployment(5, container("app", "app:v1"))
+	target.Object = newDeployment(2, container("app", "app:v1"))
+	_, _, conflicts, err = createThreeWayPatch(target, original, current)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"spec.replicas"}, conflicts)
+}
+
+func TestCreateThreeWayPatchDrift(t *testing.T) {
+	mapping := &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}}
+	original := newDeployment(1, container("app", "app:v1"))
+	// the image is changed out-of-band, the chart is not changed
+	current := newDeployment(1, container("app", "app:hacked"), container("proxy", "proxy:v1"))
+
+	target := &resource.Info{Mapping: mapping, Object: newDeployment(1, container("app", "app:v1"))}
+	patch, _, conflicts, err := createThreeWayPatch(target, original, current)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(conflicts))
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "app", "image": "app:v1"}}, patchContainers(t, patch))
+
+	// custom resources
+	mapping = &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Demo"}}
+	newDemo := func(size int64, extra string) *unstructured.Unstructured {
+		spec := map[string]interface{}{"size": size}
+		if extra != "" {
+			spec["extra"] = extra
+		}
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Demo",
+			"metadata":   map[string]interface{}{"name": "demo"},
+			"spec":       spec,
+		}}
+	}
+	target = &resource.Info{Mapping: mapping, Object: newDemo(1, "")}
+	patch, patchType, _, err := createThreeWayPatch(target, newDemo(1, ""), newDemo(3, "added"))
+	assert.Nil(t, err)
+	assert.Equal(t, types.MergePatchType, patchType)
+	assert.Equal(t, `{"spec":{"size":1}}`, string(patch))
+}
+
+func TestFindConflicts(t *testing.T) {
+	original := `{"spec":{"containers":[{"name":"app","image":"app:v1"}],"paused":false}}`
+	modified := `{"spec":{"containers":[{"name":"app","image":"app:v2"}],"paused":true}}`
+	current := `{"spec":{"containers":[{"name":"app","image":"app:v3"},{"name":"proxy"}],"paused":true}}`
+
+	conflicts, err := findConflicts([]byte(original), []byte(modified), []byte(current))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"spec.containers[app].image"}, conflicts)
+}
@@ -0,0 +1,58 @@
+package kube
+
+import (
+	"sync"
+)
+
+// Options controls how the manifests are built and applied by the Client. It's shared by all the
+// operations of an action, so the skipped resources and the conflicts can be collected.
+type Options struct {
+	BuildPolicy    BuildPolicy
+	ConflictPolicy ConflictPolicy
+	// CRDPolicy is empty by default, the CRDs in the crds/ directory are created on install only, and the
+	// ones in the templates are handled like other resources
+	CRDPolicy CRDPolicy
+
+	// CRDKinds are the <group>/<Kind> of the CRDs declared in the chart
+	CRDKinds map[string]bool
+	// CRDs are the names of the CRDs declared in the chart
+	CRDs []string
+
+	lock      sync.Mutex
+	skipped   []string
+	conflicts []string
+}
+
+// Skipped returns the resources skipped since their CRDs are not served yet, in the form of <Kind>/<name>
+func (o *Options) Skipped() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]string{}, o.skipped...)
+}
+
+// Conflicts returns the fields changed by both the chart and others, in the form of <Kind>/<name>: <field>
+func (o *Options) Conflicts() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]string{}, o.conflicts...)
+}
+
+// Reset clears the skipped resources, so the options can be used to retry them. The conflicts are kept,
+// they are not found again by the retry.
+func (o *Options) Reset() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.skipped = nil
+}
+
+func (o *Options) skip(object string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.skipped = append(o.skipped, object)
+}
+
+func (o *Options) conflict(conflicts ...string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.conflicts = append(o.conflicts, conflicts...)
+}
@@ -43,6 +43,9 @@ const ReleasesDriverName = "Release"
 type Releases struct {
 	impl releaseclient.ReleaseInterface
 	Log  func(string, ...interface{})
+	// ConfigFilter is applied to the values of a release before it's stored, to keep the sensitive
+	// values out of the Release resources. May be nil
+	ConfigFilter func(map[string]interface{}) map[string]interface{}
 }
 
 // NewReleases initializes a new Releases wrapping an implementation of
@@ -165,7 +168,7 @@ func (rel *Releases) Create(key string, rls *rspb.Release) error {
 	lbs.set("createdAt", strconv.Itoa(int(time.Now().Unix())))
 
 	// create a new configmap to hold the release
-	obj, err := newReleasesObject(key, rls, lbs)
+	obj, err := newReleasesObject(key, rel.filterConfig(rls), lbs)
 	if err != nil {
 		rel.Log("create: failed to encode release %q: %s", rls.Name, err)
 		return err
@@ -192,7 +195,7 @@ func (rel *Releases) Update(key string, rls *rspb.Release) error {
 	lbs.set("modifiedAt", strconv.Itoa(int(time.Now().Unix())))
 
 	// create a new configmap object to hold the release
-	obj, err := newReleasesObject(key, rls, lbs)
+	obj, err := newReleasesObject(key, rel.filterConfig(rls), lbs)
 	if err != nil {
 		rel.Log("update: failed to encode release %q: %s", rls.Name, err)
 		return err
@@ -232,6 +235,17 @@ func (rel *Releases) Delete(key string) (rls *rspb.Release, err error) {
 	return rls, nil
 }
 
+// filterConfig returns a copy of the release with the ConfigFilter applied, the release itself is
+// still used by helm after it's stored
+func (rel *Releases) filterConfig(rls *rspb.Release) *rspb.Release {
+	if rel.ConfigFilter == nil || rls.Config == nil {
+		return rls
+	}
+	result := *rls
+	result.Config = rel.ConfigFilter(rls.Config)
+	return &result
+}
+
 // newReleasesObject constructs a kubernetes ConfigMap object
 // to store a release. Each configmap data entry is the base64
 // encoded string of a release's binary protobuf encoding.
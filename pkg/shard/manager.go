@@ -0,0 +1,272 @@
+package shard
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alauda/captain/pkg/util"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// staleLeaseAge is how long a Lease has been expired before it's deleted
+const staleLeaseAge = time.Hour
+
+// Manager keeps a Lease for this replica, and finds the other replicas by their Leases. The keys, like the
+// cluster names, are spread to the alive replicas by a consistent hash ring, each replica only handles the
+// keys it owns. A replica whose Lease is not renewed within the lease duration is considered dead, and it's
+// keys are moved to the others.
+//
+// The replicas see the changes of the members at different times, so the keys moved to this replica are
+// not owned until a lease duration after the change, when the old owners have seen it too.
+type Manager struct {
+	client        kubernetes.Interface
+	namespace     string
+	identity      string
+	leaseDuration time.Duration
+
+	// onChange is called when the members are changed or the Lease of this replica is renewed after expired,
+	// the keys owned by this replica may be changed
+	onChange func()
+
+	lock    sync.RWMutex
+	ring    *Ring
+	members []string
+	// renewTime is the last time the Lease of this replica was renewed
+	renewTime time.Time
+	// previous is the ring before the changes in the last lease duration, only it's keys of this replica
+	// are owned until a lease duration after changeTime
+	previous   *Ring
+	changeTime time.Time
+}
+
+// NewManager creates a shard manager, the Leases live in namespace. onChange is called when the keys owned by
+// this replica may be changed, it should not block
+func NewManager(client kubernetes.Interface, namespace, identity string, leaseDuration time.Duration, onChange func()) (*Manager, error) {
+	if identity == "" {
+		return nil, fmt.Errorf("the shard identity should not be empty")
+	}
+	if leaseDuration < 3*time.Second {
+		return nil, fmt.Errorf("the shard lease duration should be at least 3s, got %s", leaseDuration)
+	}
+	return &Manager{
+		client:        client,
+		namespace:     namespace,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		onChange:      onChange,
+		ring:          NewRing(nil),
+		previous:      NewRing(nil),
+	}, nil
+}
+
+// Identity returns the identity of this replica
+func (m *Manager) Identity() string {
+	return m.identity
+}
+
+// Members returns the identities of the alive replicas
+func (m *Manager) Members() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.members
+}
+
+// Owns checks if the key is handled by this replica. Nothing is owned if the Lease of this replica is
+// expired, the others may have taken over it's keys. The keys just moved to this replica are not owned
+// until the handoff is done.
+func (m *Manager) Owns(key string) bool {
+	return m.ownsAt(key, time.Now())
+}
+
+func (m *Manager) ownsAt(key string, now time.Time) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if now.Sub(m.renewTime) > m.leaseDuration {
+		return false
+	}
+	if m.ring.Get(key) != m.identity {
+		return false
+	}
+	// the old owner may not have seen the change yet
+	if now.Sub(m.changeTime) < m.leaseDuration && m.previous.Get(key) != m.identity {
+		return false
+	}
+	return true
+}
+
+// Join creates the Lease of this replica and loads the members, it should be called before Run
+func (m *Manager) Join() error {
+	return m.refresh()
+}
+
+// Run renews the Lease and refreshes the members periodically until stopCh is closed, then the Lease
+// is deleted so the others take over the keys at once.
+func (m *Manager) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting shard manager %s, lease duration: %s", m.identity, m.leaseDuration)
+	wait.Until(func() {
+		if err := m.refresh(); err != nil {
+			klog.Errorf("refresh shard members error: %s", err.Error())
+		}
+	}, m.leaseDuration/3, stopCh)
+
+	klog.Infof("Shutting down shard manager %s", m.identity)
+	err := m.client.CoordinationV1beta1().Leases(m.namespace).Delete(m.leaseName(), &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Errorf("delete shard lease %s error: %s", m.leaseName(), err.Error())
+	}
+}
+
+// refresh renews the Lease of this replica, and rebuilds the ring if the members are changed
+func (m *Manager) refresh() error {
+	now := time.Now()
+	if err := m.renew(now); err != nil {
+		return err
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{util.ShardMemberLabel: "true"})
+	list, err := m.client.CoordinationV1beta1().Leases(m.namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	members := aliveMembers(list.Items, now)
+	m.deleteStaleLeases(list.Items, now)
+
+	m.lock.Lock()
+	// the keys skipped while the Lease was expired should be handled again
+	changed := m.update(members, now)
+	m.lock.Unlock()
+
+	if changed {
+		klog.Infof("shard members of %s: %v", m.identity, members)
+		if m.onChange != nil {
+			m.onChange()
+			// the keys moved to this replica are owned after the handoff
+			time.AfterFunc(m.leaseDuration, m.onChange)
+		}
+	}
+	return nil
+}
+
+// update rebuilds the ring with the members renewed at now, and returns true if the owned keys may be changed.
+// The caller should hold the lock.
+func (m *Manager) update(members []string, now time.Time) bool {
+	expired := now.Sub(m.renewTime) > m.leaseDuration
+	changed := !reflect.DeepEqual(members, m.members)
+	m.renewTime = now
+	if !changed && !expired {
+		return false
+	}
+
+	// keep the ring before the first one of the overlapped changes
+	if now.Sub(m.changeTime) >= m.leaseDuration {
+		m.previous = m.ring
+	}
+	// the others may have taken over all the keys while the Lease was expired
+	if expired {
+		m.previous = NewRing(nil)
+	}
+	m.changeTime = now
+	m.members = members
+	m.ring = NewRing(members)
+	return true
+}
+
+// deleteStaleLeases deletes the Leases of the replicas gone for a long time, like the pods of a Deployment
+// replaced by new ones with other names
+func (m *Manager) deleteStaleLeases(leases []coordinationv1beta1.Lease, now time.Time) {
+	for _, l := range staleLeases(leases, now, staleLeaseAge) {
+		// the others may delete it or it's replica may come back at the same time
+		err := m.client.CoordinationV1beta1().Leases(m.namespace).Delete(l.Name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &l.UID, ResourceVersion: &l.ResourceVersion},
+		})
+		if err == nil {
+			klog.Infof("deleted stale shard lease %s", l.Name)
+		} else if !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			klog.Errorf("delete stale shard lease %s error: %s", l.Name, err.Error())
+		}
+	}
+}
+
+// renew updates the renew time of the Lease of this replica, creates it if not exist
+func (m *Manager) renew(now time.Time) error {
+	leases := m.client.CoordinationV1beta1().Leases(m.namespace)
+	renewTime := metav1.NewMicroTime(now)
+	seconds := int32(m.leaseDuration / time.Second)
+
+	lease, err := leases.Get(m.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1beta1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.leaseName(),
+				Namespace: m.namespace,
+				Labels:    map[string]string{util.ShardMemberLabel: "true"},
+			},
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &m.identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &renewTime,
+				RenewTime:            &renewTime,
+			},
+		}
+		_, err = leases.Create(lease)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = &m.identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &renewTime
+	_, err = leases.Update(lease)
+	return err
+}
+
+func (m *Manager) leaseName() string {
+	return util.ShardLeasePrefix + m.identity
+}
+
+// leaseExpireTime returns the time the Lease is expired, false if it's not a valid shard Lease
+func leaseExpireTime(l coordinationv1beta1.Lease) (time.Time, bool) {
+	spec := l.Spec
+	if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return time.Time{}, false
+	}
+	return spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second), true
+}
+
+// staleLeases returns the Leases expired for more than age at now
+func staleLeases(leases []coordinationv1beta1.Lease, now time.Time, age time.Duration) []coordinationv1beta1.Lease {
+	var stale []coordinationv1beta1.Lease
+	for _, l := range leases {
+		expire, ok := leaseExpireTime(l)
+		if ok && now.Sub(expire) > age {
+			stale = append(stale, l)
+		}
+	}
+	return stale
+}
+
+// aliveMembers returns the sorted identities of the Leases not expired at now
+func aliveMembers(leases []coordinationv1beta1.Lease, now time.Time) []string {
+	var members []string
+	for _, l := range leases {
+		expire, ok := leaseExpireTime(l)
+		if !ok || !l.DeletionTimestamp.IsZero() || !expire.After(now) {
+			continue
+		}
+		members = append(members, *l.Spec.HolderIdentity)
+	}
+	sort.Strings(members)
+	return members
+}
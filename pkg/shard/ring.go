@@ -0,0 +1,57 @@
+package shard
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points of each member on the ring, so the keys are spread evenly
+const virtualNodes = 100
+
+// Ring is a consistent hash ring of the members, when a member joins or leaves, only the keys owned by it
+// are moved to others
+type Ring struct {
+	hashes []uint32
+	owners map[uint32]string
+}
+
+// NewRing creates a ring of the members
+func NewRing(members []string) *Ring {
+	r := &Ring{owners: map[uint32]string{}}
+	for _, m := range members {
+		for i := 0; i < virtualNodes; i++ {
+			h := hashKey(m + "#" + strconv.Itoa(i))
+			// keep the result stable on collisions, whatever the order of members
+			if owner, ok := r.owners[h]; ok && owner < m {
+				continue
+			}
+			if _, ok := r.owners[h]; !ok {
+				r.hashes = append(r.hashes, h)
+			}
+			r.owners[h] = m
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Get returns the member who owns the key, empty if the ring has no members
+func (r *Ring) Get(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// hashKey hashes the key with sha1, fnv is not spread well enough for the similar names of the replicas
+func hashKey(key string) uint32 {
+	sum := sha1.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,146 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gsamokovarov/assert"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRing(t *testing.T) {
+	assert.Equal(t, "", NewRing(nil).Get("global"))
+
+	members := []string{"captain-0", "captain-1", "captain-2"}
+	ring := NewRing(members)
+	// the order of members does not matter
+	reversed := NewRing([]string{"captain-2", "captain-1", "captain-0"})
+
+	owned := map[string]int{}
+	before := map[string]string{}
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("cluster-%d", i)
+		owner := ring.Get(key)
+		assert.Equal(t, owner, reversed.Get(key))
+		owned[owner]++
+		before[key] = owner
+	}
+	for _, m := range members {
+		assert.True(t, owned[m] > 50)
+	}
+
+	// only the keys of the dead member are moved
+	ring = NewRing([]string{"captain-0", "captain-2"})
+	for key, owner := range before {
+		if owner != "captain-1" {
+			assert.Equal(t, owner, ring.Get(key))
+		} else {
+			assert.NotEqual(t, "captain-1", ring.Get(key))
+		}
+	}
+}
+
+func TestAliveMembers(t *testing.T) {
+	now := time.Now()
+	lease := func(identity string, renew time.Time) coordinationv1beta1.Lease {
+		seconds := int32(30)
+		renewTime := metav1.NewMicroTime(renew)
+		return coordinationv1beta1.Lease{
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &seconds,
+				RenewTime:            &renewTime,
+			},
+		}
+	}
+
+	leases := []coordinationv1beta1.Lease{
+		lease("captain-1", now.Add(-10*time.Second)),
+		lease("captain-0", now),
+		lease("captain-2", now.Add(-time.Minute)),
+		{},
+	}
+	assert.Equal(t, []string{"captain-0", "captain-1"}, aliveMembers(leases, now))
+}
+
+func TestHandoff(t *testing.T) {
+	now := time.Now()
+	m := &Manager{identity: "captain-0", leaseDuration: 30 * time.Second, ring: NewRing(nil), previous: NewRing(nil)}
+	// renew moves the clock forward and renews the Lease with the members
+	renew := func(d time.Duration, members ...string) bool {
+		now = now.Add(d)
+		return m.update(members, now)
+	}
+
+	// nothing is owned until a lease duration after joined
+	assert.True(t, renew(0, "captain-0"))
+	assert.False(t, m.ownsAt("cluster-0", now))
+	assert.False(t, renew(20*time.Second, "captain-0"))
+	assert.False(t, m.ownsAt("cluster-0", now))
+	assert.False(t, renew(20*time.Second, "captain-0"))
+	assert.True(t, m.ownsAt("cluster-0", now))
+
+	var moved, kept string
+	ring := NewRing([]string{"captain-0", "captain-1"})
+	for i := 0; moved == "" || kept == ""; i++ {
+		key := fmt.Sprintf("cluster-%d", i)
+		if ring.Get(key) == "captain-0" {
+			kept = key
+		} else {
+			moved = key
+		}
+	}
+
+	// the keys moved away are released at once, the kept ones are still owned
+	assert.True(t, renew(20*time.Second, "captain-0", "captain-1"))
+	assert.True(t, m.ownsAt(kept, now))
+	assert.False(t, m.ownsAt(moved, now))
+	assert.False(t, renew(20*time.Second, "captain-0", "captain-1"))
+
+	// the keys moved back are owned after the handoff
+	assert.True(t, renew(20*time.Second, "captain-0"))
+	assert.True(t, m.ownsAt(kept, now))
+	assert.False(t, m.ownsAt(moved, now))
+	// overlapped changes keep the ring before the first one
+	assert.True(t, renew(10*time.Second, "captain-0", "captain-2"))
+	assert.Equal(t, ring.Get(moved), m.previous.Get(moved))
+	assert.True(t, renew(10*time.Second, "captain-0"))
+	assert.False(t, m.ownsAt(moved, now.Add(20*time.Second)))
+	assert.True(t, m.ownsAt(moved, now.Add(30*time.Second)))
+
+	// nothing is owned after the Lease is expired, and the handoff starts again once renewed
+	assert.False(t, m.ownsAt(kept, now.Add(time.Minute)))
+	assert.True(t, renew(time.Minute, "captain-0"))
+	assert.False(t, m.ownsAt(kept, now))
+	assert.True(t, m.ownsAt(kept, now.Add(30*time.Second)))
+}
+
+func TestStaleLeases(t *testing.T) {
+	now := time.Now()
+	lease := func(name string, renew time.Time) coordinationv1beta1.Lease {
+		seconds := int32(30)
+		renewTime := metav1.NewMicroTime(renew)
+		return coordinationv1beta1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &name,
+				LeaseDurationSeconds: &seconds,
+				RenewTime:            &renewTime,
+			},
+		}
+	}
+
+	leases := []coordinationv1beta1.Lease{
+		lease("captain-0", now),
+		lease("captain-1", now.Add(-time.Minute)),
+		lease("captain-2", now.Add(-2*time.Hour)),
+		{ObjectMeta: metav1.ObjectMeta{Name: "invalid"}},
+	}
+	var names []string
+	for _, l := range staleLeases(leases, now, staleLeaseAge) {
+		names = append(names, l.Name)
+	}
+	assert.Equal(t, []string{"captain-2"}, names)
+}
@@ -0,0 +1,421 @@
+// Package sops decrypts values encrypted by sops (https://github.com/mozilla/sops). Only the
+// decryption is implemented, with age or PGP keys.
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/openpgp"
+	pgparmor "golang.org/x/crypto/openpgp/armor"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// MetadataKey is the top level key sops stores it's metadata in
+	MetadataKey = "sops"
+
+	// AgeKeySuffix is the suffix of the keys in the secret which contain age identities
+	AgeKeySuffix = ".agekey"
+	// PGPKeySuffix is the suffix of the keys in the secret which contain armored PGP private keys
+	PGPKeySuffix = ".asc"
+)
+
+// encryptedValue matches ENC[AES256_GCM,data:...,iv:...,tag:...,type:...]
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// Keys are the private keys used to decrypt the data key of sops
+type Keys struct {
+	Age []age.Identity
+	PGP openpgp.EntityList
+}
+
+// ParseKeys parses keys from the data of a secret. Age identities are read from the keys with suffix
+// .agekey, and armored PGP private keys from the keys with suffix .asc.
+func ParseKeys(data map[string][]byte) (*Keys, error) {
+	keys := &Keys{}
+	for name, value := range data {
+		switch {
+		case strings.HasSuffix(name, AgeKeySuffix):
+			identities, err := age.ParseIdentities(bytes.NewReader(value))
+			if err != nil {
+				return nil, fmt.Errorf("parse age identities from %s error: %s", name, err.Error())
+			}
+			keys.Age = append(keys.Age, identities...)
+		case strings.HasSuffix(name, PGPKeySuffix):
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(value))
+			if err != nil {
+				return nil, fmt.Errorf("parse pgp keys from %s error: %s", name, err.Error())
+			}
+			keys.PGP = append(keys.PGP, entities...)
+		}
+	}
+	return keys, nil
+}
+
+// IsEncrypted checks if the values are encrypted by sops
+func IsEncrypted(values map[string]interface{}) bool {
+	_, ok := values[MetadataKey].(map[string]interface{})
+	return ok
+}
+
+// EncryptedPaths returns the paths of the values encrypted by sops, the metadata is skipped. A path is the
+// keys from the root, with the index of list items formatted as a decimal number.
+func EncryptedPaths(values map[string]interface{}) [][]string {
+	var paths [][]string
+	for k, v := range values {
+		if k != MetadataKey {
+			paths = appendEncryptedPaths(paths, []string{k}, v)
+		}
+	}
+	return paths
+}
+
+func appendEncryptedPaths(paths [][]string, path []string, value interface{}) [][]string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			paths = appendEncryptedPaths(paths, append(path[:len(path):len(path)], k), item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			paths = appendEncryptedPaths(paths, append(path[:len(path):len(path)], strconv.Itoa(i)), item)
+		}
+	case string:
+		if strings.HasPrefix(v, "ENC[") {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Decrypt decrypts values encrypted by sops, the metadata is removed from the result. The MAC is verified,
+// an error is returned if the values are tampered.
+//
+// sops hashes the values in the order of the document, which is lost once the values are parsed into a
+// map, so the keys are hashed in sorted order. It's only used for the inline values of HelmRequest, whose
+// keys are sorted by the apiserver anyway, they must be encrypted with sorted keys. Use DecryptDocument
+// when the document is available.
+func Decrypt(values map[string]interface{}, keys *Keys) (map[string]interface{}, error) {
+	return decrypt(values, nil, keys)
+}
+
+// DecryptDocument decrypts values parsed from data, a yaml document encrypted by sops, like the values in
+// a ConfigMap or Secret. The MAC is verified in the order of the document.
+func DecryptDocument(data []byte, values map[string]interface{}, keys *Keys) (map[string]interface{}, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return decrypt(values, doc, keys)
+}
+
+// decrypt decrypts values, the MAC is verified with doc if not nil, or with values in sorted order
+func decrypt(values map[string]interface{}, doc yaml.MapSlice, keys *Keys) (map[string]interface{}, error) {
+	meta, ok := values[MetadataKey].(map[string]interface{})
+	if !ok {
+		return values, nil
+	}
+
+	dataKey, err := getDataKey(meta, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{}
+	for k, v := range values {
+		if k == MetadataKey {
+			continue
+		}
+		d, err := decryptTree(v, k+":", dataKey)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = d
+	}
+
+	var encrypted, decrypted interface{} = values, result
+	if doc != nil {
+		var items yaml.MapSlice
+		for _, item := range doc {
+			if item.Key != MetadataKey {
+				items = append(items, item)
+			}
+		}
+		d, err := decryptTree(items, "", dataKey)
+		if err != nil {
+			return nil, err
+		}
+		encrypted, decrypted = items, d
+	}
+	if err := verifyMAC(encrypted, decrypted, meta, dataKey); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifyMAC checks the MAC in the metadata against the decrypted values, the keys of the maps are hashed in
+// sorted order, and the items of yaml.MapSlice in their order.
+func verifyMAC(encrypted, decrypted interface{}, meta map[string]interface{}, dataKey []byte) error {
+	mac, _ := meta["mac"].(string)
+	if mac == "" {
+		return fmt.Errorf("no mac in the sops metadata")
+	}
+	lastModified, _ := meta["lastmodified"].(string)
+	expected, err := decryptValue(mac, lastModified, dataKey)
+	if err != nil {
+		return fmt.Errorf("decrypt mac error: %s", err.Error())
+	}
+
+	onlyEncrypted, _ := meta["mac_only_encrypted"].(bool)
+	h := sha512.New()
+	if err := hashTree(h, encrypted, decrypted, onlyEncrypted); err != nil {
+		return err
+	}
+	if fmt.Sprintf("%X", h.Sum(nil)) != expected {
+		if _, ok := decrypted.(map[string]interface{}); ok {
+			return fmt.Errorf("mac mismatch, the values are tampered or not encrypted with sorted keys")
+		}
+		return fmt.Errorf("mac mismatch, the values are tampered")
+	}
+	return nil
+}
+
+// hashTree writes the decrypted leaf values to h the same way as sops, encrypted is the same tree before
+// decryption, to know which values were encrypted. The keys not in decrypted (the metadata) are skipped.
+func hashTree(h hash.Hash, encrypted, decrypted interface{}, onlyEncrypted bool) error {
+	switch v := decrypted.(type) {
+	case yaml.MapSlice:
+		e, _ := encrypted.(yaml.MapSlice)
+		for i, item := range v {
+			var original interface{}
+			if i < len(e) {
+				original = e[i].Value
+			}
+			if err := hashTree(h, original, item.Value, onlyEncrypted); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e, _ := encrypted.(map[string]interface{})
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := hashTree(h, e[k], v[k], onlyEncrypted); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		e, _ := encrypted.([]interface{})
+		for i, item := range v {
+			var original interface{}
+			if i < len(e) {
+				original = e[i]
+			}
+			if err := hashTree(h, original, item, onlyEncrypted); err != nil {
+				return err
+			}
+		}
+	case nil:
+		// sops skips nil values
+	default:
+		if s, ok := encrypted.(string); onlyEncrypted && !(ok && strings.HasPrefix(s, "ENC[")) {
+			return nil
+		}
+		b, err := toBytes(v)
+		if err != nil {
+			return err
+		}
+		h.Write(b)
+	}
+	return nil
+}
+
+// toBytes formats a leaf value the same way as sops does for the MAC
+func toBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case int:
+		return []byte(strconv.Itoa(v)), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case bool:
+		if v {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	default:
+		return nil, fmt.Errorf("unknown value type %T", value)
+	}
+}
+
+// decryptTree decrypts all the encrypted values in the tree. path is the additional data sops uses
+// for the value, which is the keys from the root joined by ':', with a trailing ':'. The index of
+// list items are not part of the path.
+func decryptTree(value interface{}, path string, dataKey []byte) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		result := make(yaml.MapSlice, 0, len(v))
+		for _, item := range v {
+			d, err := decryptTree(item.Value, path+fmt.Sprint(item.Key)+":", dataKey)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, yaml.MapItem{Key: item.Key, Value: d})
+		}
+		return result, nil
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for k, item := range v {
+			d, err := decryptTree(item, path+k+":", dataKey)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = d
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			d, err := decryptTree(item, path, dataKey)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, d)
+		}
+		return result, nil
+	case string:
+		if !strings.HasPrefix(v, "ENC[") {
+			return v, nil
+		}
+		d, err := decryptValue(v, path, dataKey)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt value of %s error: %s", strings.TrimSuffix(path, ":"), err.Error())
+		}
+		return d, nil
+	default:
+		return value, nil
+	}
+}
+
+// decryptValue decrypts a single value encrypted with AES256_GCM
+func decryptValue(value, additionalData string, dataKey []byte) (interface{}, error) {
+	matches := encryptedValue.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid encrypted value format")
+	}
+	var parts [][]byte
+	for _, item := range matches[1:4] {
+		b, err := base64.StdEncoding.DecodeString(item)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, b)
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+
+	s := string(plain)
+	switch matches[4] {
+	case "str", "bytes", "comment":
+		return s, nil
+	case "int":
+		return strconv.Atoi(s)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	default:
+		return nil, fmt.Errorf("unknown type %s", matches[4])
+	}
+}
+
+// getDataKey decrypts the data key with one of the keys
+func getDataKey(meta map[string]interface{}, keys *Keys) ([]byte, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("no keys to decrypt the values")
+	}
+
+	if len(keys.Age) > 0 {
+		for _, item := range toList(meta["age"]) {
+			enc, _ := item["enc"].(string)
+			r, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), keys.Age...)
+			if err != nil {
+				continue
+			}
+			if key, err := ioutil.ReadAll(r); err == nil {
+				return key, nil
+			}
+		}
+	}
+
+	if len(keys.PGP) > 0 {
+		for _, item := range toList(meta["pgp"]) {
+			enc, _ := item["enc"].(string)
+			block, err := armorDecode(enc)
+			if err != nil {
+				continue
+			}
+			md, err := openpgp.ReadMessage(block, keys.PGP, nil, nil)
+			if err != nil {
+				continue
+			}
+			if key, err := ioutil.ReadAll(md.UnverifiedBody); err == nil {
+				return key, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("none of the keys can decrypt the data key")
+}
+
+// armorDecode returns the body of an armored PGP message
+func armorDecode(data string) (io.Reader, error) {
+	block, err := pgparmor.Decode(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return block.Body, nil
+}
+
+func toList(value interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			result = append(result, m)
+		}
+	}
+	return result
+}
@@ -0,0 +1,172 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/ghodss/yaml"
+	"github.com/gsamokovarov/assert"
+	yamlv2 "gopkg.in/yaml.v2"
+)
+
+// encryptValue encrypts a value the same way as sops
+func encryptValue(t *testing.T, value, path, typ string, dataKey []byte) string {
+	block, err := aes.NewCipher(dataKey)
+	assert.Nil(t, err)
+	iv := make([]byte, 32)
+	rand.Read(iv)
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	assert.Nil(t, err)
+	out := gcm.Seal(nil, iv, []byte(value), []byte(path))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ)
+}
+
+// computeMAC computes the encrypted MAC of the values, with the keys sorted
+func computeMAC(t *testing.T, values []string, lastModified string, dataKey []byte) string {
+	h := sha512.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+	}
+	return encryptValue(t, fmt.Sprintf("%X", h.Sum(nil)), lastModified, "str", dataKey)
+}
+
+// setupKeys returns the keys to decrypt the data key, the data key and the age metadata of sops
+func setupKeys(t *testing.T) (*Keys, []byte, []interface{}) {
+	identity, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, identity.Recipient())
+	assert.Nil(t, err)
+	w.Write(dataKey)
+	w.Close()
+	aw.Close()
+
+	keys, err := ParseKeys(map[string][]byte{
+		"identity.agekey": []byte(identity.String()),
+		"README":          []byte("ignored"),
+	})
+	assert.Nil(t, err)
+	return keys, dataKey, []interface{}{
+		map[string]interface{}{"recipient": identity.Recipient().String(), "enc": buf.String()},
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	keys, dataKey, ageMeta := setupKeys(t)
+
+	values := map[string]interface{}{
+		"image": "nginx",
+		"db": map[string]interface{}{
+			"password": encryptValue(t, "secret", "db:password:", "str", dataKey),
+			"port":     encryptValue(t, "3306", "db:port:", "int", dataKey),
+			"hosts": []interface{}{
+				encryptValue(t, "a", "db:hosts:", "str", dataKey),
+			},
+		},
+		"sops": map[string]interface{}{
+			"age":          ageMeta,
+			"lastmodified": "2019-10-01T00:00:00Z",
+			// db.hosts, db.password, db.port, image
+			"mac": computeMAC(t, []string{"a", "secret", "3306", "nginx"}, "2019-10-01T00:00:00Z", dataKey),
+		},
+	}
+	assert.True(t, IsEncrypted(values))
+	paths := EncryptedPaths(values)
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Join(paths[i], ".") < strings.Join(paths[j], ".")
+	})
+	assert.Equal(t, [][]string{{"db", "hosts", "0"}, {"db", "password"}, {"db", "port"}}, paths)
+
+	result, err := Decrypt(values, keys)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"image": "nginx",
+		"db": map[string]interface{}{
+			"password": "secret",
+			"port":     3306,
+			"hosts":    []interface{}{"a"},
+		},
+	}, result)
+
+	// tampered
+	values["image"] = "redis"
+	_, err = Decrypt(values, keys)
+	assert.NotNil(t, err)
+	values["image"] = "nginx"
+
+	// no mac
+	meta := values["sops"].(map[string]interface{})
+	mac := meta["mac"]
+	delete(meta, "mac")
+	_, err = Decrypt(values, keys)
+	assert.NotNil(t, err)
+	meta["mac"] = mac
+
+	// wrong path
+	values["db"].(map[string]interface{})["password"] = encryptValue(t, "secret", "password:", "str", dataKey)
+	_, err = Decrypt(values, keys)
+	assert.NotNil(t, err)
+
+	other, _ := age.GenerateX25519Identity()
+	_, err = Decrypt(values, &Keys{Age: []age.Identity{other}})
+	assert.NotNil(t, err)
+}
+
+func TestDecryptDocument(t *testing.T) {
+	keys, dataKey, ageMeta := setupKeys(t)
+
+	// the keys are not sorted, as written by sops -e
+	doc := yamlv2.MapSlice{
+		{Key: "image", Value: "nginx"},
+		{Key: "db", Value: yamlv2.MapSlice{
+			{Key: "port", Value: encryptValue(t, "3306", "db:port:", "int", dataKey)},
+			{Key: "password", Value: encryptValue(t, "secret", "db:password:", "str", dataKey)},
+		}},
+		{Key: "sops", Value: yamlv2.MapSlice{
+			{Key: "age", Value: ageMeta},
+			{Key: "lastmodified", Value: "2019-10-01T00:00:00Z"},
+			{Key: "mac", Value: computeMAC(t, []string{"nginx", "3306", "secret"}, "2019-10-01T00:00:00Z", dataKey)},
+		}},
+	}
+	data, err := yamlv2.Marshal(doc)
+	assert.Nil(t, err)
+	var values map[string]interface{}
+	assert.Nil(t, yaml.Unmarshal(data, &values))
+
+	result, err := DecryptDocument(data, values, keys)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"image": "nginx",
+		"db": map[string]interface{}{
+			"password": "secret",
+			"port":     3306,
+		},
+	}, result)
+
+	// the sorted order is only for the inline values
+	_, err = Decrypt(values, keys)
+	assert.NotNil(t, err)
+
+	// tampered
+	tampered := []byte(strings.Replace(string(data), "nginx", "redis", 1))
+	assert.Nil(t, yaml.Unmarshal(tampered, &values))
+	_, err = DecryptDocument(tampered, values, keys)
+	assert.NotNil(t, err)
+}
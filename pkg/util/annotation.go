@@ -0,0 +1,34 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetAnnotation returns the value of an annotation, "" if not set
+func GetAnnotation(obj metav1.Object, key string) string {
+	return obj.GetAnnotations()[key]
+}
+
+// IsAnnotationTrue check if an annotation is set to a true value, like "true" or "1"
+func IsAnnotationTrue(obj metav1.Object, key string) bool {
+	v, err := strconv.ParseBool(GetAnnotation(obj, key))
+	return err == nil && v
+}
+
+// IsExportedTo checks if the exports of a HelmRequest can be read by the HelmRequests in namespace, by the
+// exports-to annotation
+func IsExportedTo(obj metav1.Object, namespace string) bool {
+	if obj.GetNamespace() == namespace {
+		return true
+	}
+	for _, ns := range strings.Split(GetAnnotation(obj, ExportsToKey), ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsAnnotationTrue(t *testing.T) {
+	obj := &metav1.ObjectMeta{
+		Annotations: map[string]string{
+			"a": "true",
+			"b": "false",
+			"c": "yes",
+		},
+	}
+	assert.True(t, IsAnnotationTrue(obj, "a"))
+	assert.False(t, IsAnnotationTrue(obj, "b"))
+	assert.False(t, IsAnnotationTrue(obj, "c"))
+	assert.False(t, IsAnnotationTrue(obj, "d"))
+	assert.False(t, IsAnnotationTrue(&metav1.ObjectMeta{}, "a"))
+}
+
+func TestIsExportedTo(t *testing.T) {
+	obj := &metav1.ObjectMeta{Namespace: "a"}
+	assert.True(t, IsExportedTo(obj, "a"))
+	assert.False(t, IsExportedTo(obj, "b"))
+
+	obj.Annotations = map[string]string{ExportsToKey: "b, c"}
+	assert.True(t, IsExportedTo(obj, "b"))
+	assert.True(t, IsExportedTo(obj, "c"))
+	assert.False(t, IsExportedTo(obj, "d"))
+
+	obj.Annotations[ExportsToKey] = "*"
+	assert.True(t, IsExportedTo(obj, "d"))
+}
@@ -7,9 +7,125 @@ const (
 	//LeaderLockName is the name of lock for leader election
 	LeaderLockName = "captain-controller-lock"
 
+	// ShardLeasePrefix is the name prefix of the Lease of each replica when sharding is enabled
+	ShardLeasePrefix = "captain-shard-"
+
+	// ShardMemberLabel is the label on the Leases of the replicas when sharding is enabled
+	ShardMemberLabel = "captain.alauda.io/shard-member"
+
 	// FinalizerName is the finalizer name we append to each HelmRequest resource
 	FinalizerName = "captain.alauda.io"
 
 	// ProjectKey is the annotation key for project
 	ProjectKey = "alauda.io/project"
+
+	// VerifyKey is the annotation key on ChartRepo or HelmRequest to require signed charts
+	VerifyKey = "captain.alauda.io/verify"
+
+	// KeyringSecretKey is the annotation key on ChartRepo or HelmRequest for the name of the keyring
+	// secret. The secret must live in the ChartRepo namespace.
+	KeyringSecretKey = "captain.alauda.io/keyring-secret"
+
+	// RepoTypeKey is the annotation key on ChartRepo for it's type
+	RepoTypeKey = "captain.alauda.io/repo-type"
+
+	// RefreshAtKey is the annotation key on ChartRepo for the last time the charts of a local ChartRepo were changed.
+	// It's set by captain, so the replica handles the ChartRepo is notified by it's informer
+	RefreshAtKey = "captain.alauda.io/refresh-at"
+
+	// RepoTypeLocal means the charts of this ChartRepo are stored by captain itself
+	RepoTypeLocal = "local"
+
+	// InsecureSkipTLSVerifyKey is the annotation key on Cluster to skip the verification of the apiserver's
+	// certificate. Only for test clusters
+	InsecureSkipTLSVerifyKey = "captain.alauda.io/insecure-skip-tls-verify"
+
+	// DeprecatedAPIPolicyKey is the annotation key on HelmRequest to choose what to do when the chart uses
+	// deprecated APIs, or APIs not served by the target cluster
+	DeprecatedAPIPolicyKey = "captain.alauda.io/deprecated-api-policy"
+
+	// DeprecatedAPIPolicyWarn means only events and conditions are recorded. This is the default
+	DeprecatedAPIPolicyWarn = "Warn"
+
+	// DeprecatedAPIPolicyBlock means the sync is blocked if any API is removed in or not served by the target cluster
+	DeprecatedAPIPolicyBlock = "Block"
+
+	// DeprecatedAPIPolicyBlockDeprecated means the sync is blocked if any API is deprecated
+	DeprecatedAPIPolicyBlockDeprecated = "BlockDeprecated"
+
+	// BuildPolicyKey is the annotation key on HelmRequest to choose what to do when some resources of the
+	// chart can not be built, like the custom resources whose CRDs are not served yet
+	BuildPolicyKey = "captain.alauda.io/build-policy"
+
+	// BuildPolicyStrict means the sync fails on any build error. This is the default
+	BuildPolicyStrict = "Strict"
+
+	// BuildPolicySkipPendingCRDs means the custom resources whose CRDs are in the same chart are skipped until
+	// the CRDs are established, then the sync is retried once
+	BuildPolicySkipPendingCRDs = "SkipPendingCRDs"
+
+	// ConflictPolicyKey is the annotation key on HelmRequest to choose what to do when a field changed by the
+	// chart is also changed by others in the cluster
+	ConflictPolicyKey = "captain.alauda.io/conflict-policy"
+
+	// ConflictPolicyOverwrite means the value of the chart is applied, the conflicts are recorded in the
+	// status. This is the default
+	ConflictPolicyOverwrite = "Overwrite"
+
+	// ConflictPolicyFail means the sync fails if there is any conflict
+	ConflictPolicyFail = "Fail"
+
+	// CRDPolicyKey is the annotation key on HelmRequest to choose how the CRDs of the chart are installed,
+	// upgraded and deleted. If not set, helm's default behavior is kept
+	CRDPolicyKey = "captain.alauda.io/crd-policy"
+
+	// CRDPolicySkip means the CRDs of the chart are never created, updated or deleted
+	CRDPolicySkip = "Skip"
+
+	// CRDPolicyCreate means the CRDs are created if they don't exist, but never updated
+	CRDPolicyCreate = "Create"
+
+	// CRDPolicyCreateReplace means the CRDs are created or updated on every install and upgrade
+	CRDPolicyCreateReplace = "CreateReplace"
+
+	// CRDPolicyNeverDelete means the CRDs are created or updated, but never deleted
+	CRDPolicyNeverDelete = "Never-delete"
+
+	// KeyringDataKey is the key of the public keyring in the keyring secret
+	KeyringDataKey = "pubring.gpg"
+
+	// DependentsPolicyKey is the annotation key on HelmRequest to choose what to do with the HelmRequests
+	// depend on it when it's being deleted
+	DependentsPolicyKey = "captain.alauda.io/dependents-policy"
+
+	// DependentsPolicyBlock means the deletion waits until all the dependents are deleted. This is the default
+	DependentsPolicyBlock = "Block"
+
+	// DependentsPolicyCascade means the dependents are deleted first
+	DependentsPolicyCascade = "Cascade"
+
+	// ExportsToKey is the annotation key on HelmRequest for the comma separated namespaces whose HelmRequests
+	// can read it's exports and status, "*" means all the namespaces. The same namespace is always allowed
+	ExportsToKey = "captain.alauda.io/exports-to"
+
+	// DeletionPolicyKey is the annotation key on HelmRequest to choose what to do with the release when
+	// the HelmRequest is deleted
+	DeletionPolicyKey = "captain.alauda.io/deletion-policy"
+
+	// DeletionPolicyDelete means the release will be uninstalled. This is the default
+	DeletionPolicyDelete = "Delete"
+
+	// DeletionPolicyRetain means the release and it's resources are left as they are, only the HelmRequest is deleted
+	DeletionPolicyRetain = "Retain"
+
+	// KeepHistoryKey is the annotation key on HelmRequest to keep the release history when uninstall
+	KeepHistoryKey = "captain.alauda.io/keep-history"
+
+	// ValuesSourceLabel is the label on ConfigMap or Secret to be watched by captain, the HelmRequests read values
+	// from the labeled ones are upgraded when they are changed
+	ValuesSourceLabel = "captain.alauda.io/values-source"
+
+	// SourcesHashKey is the annotation key on HelmRequest for the hash of the valuesFrom sources used by the
+	// last sync. It's set by captain.
+	SourcesHashKey = "captain.alauda.io/sources-hash"
 )
@@ -0,0 +1,204 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// maxSuggestions is the max number of chart names or versions in the error message
+const maxSuggestions = 5
+
+// validateChart checks that the chart of a HelmRequest exist in a known ChartRepo, and at least one of
+// it's versions matches .spec.version. Charts not in <repo>/<chart> format(like urls) are not checked.
+// If the ChartRepo is not synced yet, or somethings goes wrong when retrieve the data, the check will
+// be skipped.
+func (v *helmRequestValidator) validateChart(hr *v1alpha1.HelmRequest) error {
+	if strings.Contains(hr.Spec.Chart, "://") || strings.Count(hr.Spec.Chart, "/") != 1 {
+		klog.V(4).Infof("chart %s is not in <repo>/<chart> format, skip check", hr.Spec.Chart)
+		return nil
+	}
+	repoName, chartName := util.ParseChartName(hr.Spec.Chart)
+	client := v.appClient.AppV1alpha1()
+
+	repo, err := client.ChartRepos(v.chartRepoNamespace).Get(repoName, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("chartrepo %s not found", repoName)
+		}
+		klog.Warningf("get chartrepo %s error, skip chart check: %s", repoName, err.Error())
+		return nil
+	}
+	if repo.Status.Phase != v1alpha1.ChartRepoSynced {
+		klog.Infof("chartrepo %s is not synced yet, skip chart check", repoName)
+		return nil
+	}
+
+	name := fmt.Sprintf("%s.%s", strings.ToLower(chartName), repoName)
+	chart, err := client.Charts(v.chartRepoNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Warningf("get chart %s error, skip chart check: %s", name, err.Error())
+			return nil
+		}
+		msg := fmt.Sprintf("chart %s not found in chartrepo %s", chartName, repoName)
+		list, err := client.Charts(v.chartRepoNamespace).List(metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("repo=%s", repoName),
+		})
+		if err == nil {
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, strings.TrimSuffix(item.GetName(), "."+repoName))
+			}
+			if similar := closestNames(strings.ToLower(chartName), names); len(similar) > 0 {
+				msg = fmt.Sprintf("%s, did you mean: %s", msg, strings.Join(similar, ", "))
+			}
+		}
+		return errors.New(msg)
+	}
+
+	if hr.Spec.Version == "" {
+		return nil
+	}
+
+	var versions []string
+	for _, item := range chart.Spec.Versions {
+		if item != nil {
+			versions = append(versions, item.Version)
+		}
+	}
+	return checkVersion(hr.Spec.Chart, hr.Spec.Version, versions)
+}
+
+// checkVersion checks that at least one of the versions matches the constraint, if not, the closest
+// versions will be listed in the error
+func checkVersion(chart, constraint string, versions []string) error {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return fmt.Errorf("invalid version %s for chart %s: %s", constraint, chart, err.Error())
+	}
+
+	for _, item := range versions {
+		v, err := semver.NewVersion(item)
+		if err == nil && c.Check(v) {
+			return nil
+		}
+	}
+
+	msg := fmt.Sprintf("no version of chart %s matches %s", chart, constraint)
+	if closest := closestVersions(constraint, versions); len(closest) > 0 {
+		msg = fmt.Sprintf("%s, available versions: %s", msg, strings.Join(closest, ", "))
+	}
+	return errors.New(msg)
+}
+
+// closestVersions returns the versions closest to target. If target is not a single version (a range),
+// returns the latest versions.
+func closestVersions(target string, versions []string) []string {
+	var vs []*semver.Version
+	for _, item := range versions {
+		v, err := semver.NewVersion(item)
+		if err == nil {
+			vs = append(vs, v)
+		}
+	}
+
+	t, err := semver.NewVersion(strings.TrimLeft(target, "=v"))
+	sort.SliceStable(vs, func(i, j int) bool {
+		if err != nil {
+			return vs[i].GreaterThan(vs[j])
+		}
+		di, dj := versionDistance(t, vs[i]), versionDistance(t, vs[j])
+		if di != dj {
+			return di < dj
+		}
+		return vs[i].GreaterThan(vs[j])
+	})
+
+	var result []string
+	for i := 0; i < len(vs) && i < maxSuggestions; i++ {
+		result = append(result, vs[i].Original())
+	}
+	return result
+}
+
+// versionDistance is a rough distance between two versions, major > minor > patch
+func versionDistance(a, b *semver.Version) int64 {
+	abs := func(x int64) int64 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	return abs(a.Major()-b.Major())*1000000 + abs(a.Minor()-b.Minor())*1000 + abs(a.Patch()-b.Patch())
+}
+
+// closestNames returns the names similar to target, sorted by edit distance
+func closestNames(target string, names []string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	// names too different are not helpful
+	limit := len(target)/2 + 1
+	var candidates []candidate
+	for _, name := range names {
+		d := editDistance(target, name)
+		if d <= limit || strings.Contains(name, target) {
+			candidates = append(candidates, candidate{name: name, distance: d})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var result []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		result = append(result, candidates[i].name)
+	}
+	return result
+}
+
+// editDistance is the levenshtein distance of two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
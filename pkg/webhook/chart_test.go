@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/gsamokovarov/assert"
+)
+
+func TestCheckVersion(t *testing.T) {
+	versions := []string{"1.0.0", "1.2.0", "1.2.3", "2.0.0", "0.9.0"}
+
+	t.Run("exact", func(t *testing.T) {
+		assert.Nil(t, checkVersion("stable/demo", "1.2.3", versions))
+	})
+
+	t.Run("range", func(t *testing.T) {
+		assert.Nil(t, checkVersion("stable/demo", "~1.2.0", versions))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := checkVersion("stable/demo", "1.2.4", versions)
+		assert.NotNil(t, err)
+		assert.Equal(t, "no version of chart stable/demo matches 1.2.4, available versions: 1.2.3, 1.2.0, 1.0.0, 2.0.0, 0.9.0", err.Error())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.NotNil(t, checkVersion("stable/demo", "abc", versions))
+	})
+}
+
+func TestClosestNames(t *testing.T) {
+	names := []string{"nginx-ingress", "nginx-ldapauth-proxy", "mysql", "redis"}
+	assert.Equal(t, []string{"nginx-ingress"}, closestNames("nginx-ingres", names))
+	assert.Equal(t, []string{"nginx-ingress", "nginx-ldapauth-proxy"}, closestNames("nginx", names))
+	assert.Equal(t, 0, len(closestNames("postgresql", names)))
+}
@@ -20,7 +20,8 @@ func validateChartRepoHandler(ws *webhook.Server) error {
 }
 
 //RegisterHandlers register validating and mutating webhook for captain
-func RegisterHandlers(mgr manager.Manager) error {
+// chartRepoNamespace is used to find the charts of HelmRequests
+func RegisterHandlers(mgr manager.Manager, chartRepoNamespace string) error {
 	// get and add it to manager
 	ws := mgr.GetWebhookServer()
 
@@ -28,7 +29,13 @@ func RegisterHandlers(mgr manager.Manager) error {
 		return err
 	}
 
-	handler := admission.ValidatingWebhookFor(&v1alpha1.HelmRequest{})
+	validator, err := newHelmRequestValidator(mgr.GetConfig(), chartRepoNamespace)
+	if err != nil {
+		klog.Error("create helmrequest validator error: ", err)
+		return err
+	}
+	// validator runs the validation of the HelmRequest type itself
+	handler := &admission.Webhook{Handler: validator}
 	if err := handler.InjectLogger(log.Log.WithName("validating")); err != nil {
 		klog.Error("inject logger to validating webhook handler error: ", err)
 		return err
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/alauda/component-base/regex"
+
+	"github.com/alauda/captain/pkg/helm"
+	"github.com/alauda/captain/pkg/helmrequest"
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	clientset "github.com/alauda/helm-crds/pkg/client/clientset/versioned"
+	funk "github.com/thoas/go-funk"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// helmRequestValidator validates HelmRequest against it's chart, this requires access to the repo cache
+// and cluster, so it cannot be done in the HelmRequest type itself.
+type helmRequestValidator struct {
+	// cfg is the rest config of the current cluster, used to read valuesFrom sources
+	cfg *rest.Config
+	// appClient is used to retrieve ChartRepo and Chart resources
+	appClient clientset.Interface
+	// chartRepoNamespace is the namespace that all the ChartRepo resource lives in
+	chartRepoNamespace string
+
+	decoder *admission.Decoder
+}
+
+// newHelmRequestValidator create a validator for HelmRequest
+func newHelmRequestValidator(cfg *rest.Config, chartRepoNamespace string) (*helmRequestValidator, error) {
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &helmRequestValidator{
+		cfg:                cfg,
+		appClient:          client,
+		chartRepoNamespace: chartRepoNamespace,
+	}, nil
+}
+
+var _ admission.DecoderInjector = &helmRequestValidator{}
+
+// InjectDecoder injects the decoder into the validator
+func (v *helmRequestValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle handles admission requests
+func (v *helmRequestValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != v1beta1.Create && req.Operation != v1beta1.Update {
+		return admission.Allowed("")
+	}
+
+	hr := &v1alpha1.HelmRequest{}
+	if err := v.decoder.Decode(req, hr); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var old *v1alpha1.HelmRequest
+	if req.Operation == v1beta1.Update {
+		old = &v1alpha1.HelmRequest{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	if err := validateSpec(hr, old); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	if err := validateAnnotations(hr); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	if !hr.DeletionTimestamp.IsZero() {
+		return admission.Allowed("")
+	}
+
+	// metadata only changes, like finalizers
+	if old != nil && reflect.DeepEqual(old.Spec, hr.Spec) {
+		return admission.Allowed("")
+	}
+
+	// dependencies cannot be updated, so only check them on create
+	if old == nil {
+		if err := v.validateDependencies(hr); err != nil {
+			klog.Infof("dependencies validation failed for HelmRequest %s/%s: %s", hr.GetNamespace(), hr.GetName(), err.Error())
+			return admission.Denied(err.Error())
+		}
+	}
+
+	if err := v.validateChart(hr); err != nil {
+		klog.Infof("chart validation failed for HelmRequest %s/%s: %s", hr.GetNamespace(), hr.GetName(), err.Error())
+		return admission.Denied(err.Error())
+	}
+
+	if err := helm.ValidateReservedValues(hr); err != nil {
+		return admission.Denied(fmt.Sprintf("invalid values: %s", err.Error()))
+	}
+
+	if err := helm.ValidateValues(hr, v.cfg); err != nil {
+		klog.Infof("values validation failed for HelmRequest %s/%s: %s", hr.GetNamespace(), hr.GetName(), err.Error())
+		return admission.Denied(fmt.Sprintf("invalid values for chart %s: %s", hr.Spec.Chart, err.Error()))
+	}
+
+	return admission.Allowed("")
+}
+
+// validateSpec runs the validation defined in the HelmRequest type. It does not know about dependencies
+// in the <namespace>/<name> format, so they are checked here and converted to names before that.
+func validateSpec(hr, old *v1alpha1.HelmRequest) error {
+	if old != nil && !reflect.DeepEqual(old.Spec.Dependencies, hr.Spec.Dependencies) {
+		return fmt.Errorf("dependencies cannot be updated after create")
+	}
+
+	hr = hr.DeepCopy()
+	for i, dep := range hr.Spec.Dependencies {
+		namespace, name := helmrequest.ParseDependency(dep, hr.GetNamespace())
+		if !regex.IsValidResourceName(namespace) || !regex.IsValidResourceName(name) {
+			return fmt.Errorf("invalid dependency %s, should be <name> or <namespace>/<name>", dep)
+		}
+		hr.Spec.Dependencies[i] = name
+	}
+
+	if old == nil {
+		return hr.ValidateCreate()
+	}
+	old = old.DeepCopy()
+	old.Spec.Dependencies = hr.Spec.Dependencies
+	return hr.ValidateUpdate(old)
+}
+
+// validateAnnotations checks the values of the annotations which control the behavior of captain
+func validateAnnotations(hr *v1alpha1.HelmRequest) error {
+	allowed := map[string][]string{
+		util.DeletionPolicyKey:   {util.DeletionPolicyDelete, util.DeletionPolicyRetain},
+		util.DependentsPolicyKey: {util.DependentsPolicyBlock, util.DependentsPolicyCascade},
+		util.DeprecatedAPIPolicyKey: {util.DeprecatedAPIPolicyWarn, util.DeprecatedAPIPolicyBlock,
+			util.DeprecatedAPIPolicyBlockDeprecated},
+		util.BuildPolicyKey:    {util.BuildPolicyStrict, util.BuildPolicySkipPendingCRDs},
+		util.ConflictPolicyKey: {util.ConflictPolicyOverwrite, util.ConflictPolicyFail},
+		util.CRDPolicyKey: {util.CRDPolicySkip, util.CRDPolicyCreate, util.CRDPolicyCreateReplace,
+			util.CRDPolicyNeverDelete},
+	}
+	for key, values := range allowed {
+		value := util.GetAnnotation(hr, key)
+		if value != "" && !funk.ContainsString(values, value) {
+			return fmt.Errorf("invalid value %s for annotation %s, should be one of: %s", value, key, strings.Join(values, ", "))
+		}
+	}
+	return nil
+}
+
+// validateDependencies rejects HelmRequest whose dependencies have a cycle. Dependencies not exist are
+// allowed, since they may be created later.
+func (v *helmRequestValidator) validateDependencies(hr *v1alpha1.HelmRequest) error {
+	cycle, err := helmrequest.FindCycle(hr, func(namespace, name string) (*v1alpha1.HelmRequest, error) {
+		// the HelmRequest being created is not in the cluster yet
+		if namespace == hr.GetNamespace() && name == hr.GetName() {
+			return hr, nil
+		}
+		return v.appClient.AppV1alpha1().HelmRequests(namespace).Get(name, metav1.GetOptions{})
+	})
+	if err != nil {
+		// not able to check, let the controller report it
+		klog.Warningf("check dependency cycle for HelmRequest %s/%s error: %s", hr.GetNamespace(), hr.GetName(), err.Error())
+		return nil
+	}
+	if cycle != nil {
+		return fmt.Errorf("dependency cycle found: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/alauda/captain/pkg/util"
+	"github.com/alauda/helm-crds/pkg/apis/app/v1alpha1"
+	"github.com/gsamokovarov/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateSpec(t *testing.T) {
+	hr := &v1alpha1.HelmRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+		Spec: v1alpha1.HelmRequestSpec{
+			Chart:        "stable/nginx",
+			Dependencies: []string{"b", "kube-system/c"},
+		},
+	}
+	assert.Nil(t, validateSpec(hr, nil))
+	assert.Nil(t, validateSpec(hr, hr.DeepCopy()))
+	// the original object should not be changed
+	assert.Equal(t, "kube-system/c", hr.Spec.Dependencies[1])
+
+	old := hr.DeepCopy()
+	old.Spec.Dependencies[1] = "default/c"
+	assert.NotNil(t, validateSpec(hr, old))
+
+	hr.Spec.Dependencies = []string{"a/b/c"}
+	assert.NotNil(t, validateSpec(hr, nil))
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	hr := &v1alpha1.HelmRequest{}
+	assert.Nil(t, validateAnnotations(hr))
+
+	hr.Annotations = map[string]string{util.DeletionPolicyKey: util.DeletionPolicyRetain}
+	assert.Nil(t, validateAnnotations(hr))
+
+	hr.Annotations[util.DependentsPolicyKey] = "Orphan"
+	assert.NotNil(t, validateAnnotations(hr))
+}